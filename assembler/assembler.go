@@ -0,0 +1,79 @@
+// Package assembler turns Intel-syntax source into machine code that the
+// emulator accepts.
+//
+//	start:  mov rax, 5        ; comments start with ';' or '#'
+//	        add rax, rbx
+//	        cmp rax, 0x10
+//	        jl start
+//
+// Every instruction the emulator executes can be written, memory operands
+// take an optional "byte/word/dword/qword ptr" prefix, [rip+label] addresses
+// a label, and numbers may be decimal, 0x hexadecimal or 0b binary. Branches
+// use the short rel8 form whenever the target is in range.
+package assembler
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Assemble assembles src. Errors are *Error values carrying the line and
+// column of the offending token.
+func Assemble(src string) ([]byte, error) {
+	stmts, labelIndex, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start with every branch short and widen the ones whose target turns
+	// out to be too far away; widening only ever moves code further apart,
+	// so this terminates.
+	long := make([]bool, len(stmts))
+	for {
+		addrs := make([]int, len(stmts)+1)
+		for i := range stmts {
+			b, err := encode(&stmts[i], addrs[i], nil, long[i])
+			if errors.Is(err, errShortRange) {
+				long[i] = true
+				b, err = encode(&stmts[i], addrs[i], nil, true)
+			}
+			if err != nil {
+				return nil, err
+			}
+			addrs[i+1] = addrs[i] + len(b)
+		}
+
+		labels := make(map[string]int, len(labelIndex))
+		for name, i := range labelIndex {
+			labels[name] = addrs[i]
+		}
+
+		var code []byte
+		widened := false
+		for i := range stmts {
+			b, err := encode(&stmts[i], addrs[i], labels, long[i])
+			if errors.Is(err, errShortRange) {
+				long[i] = true
+				widened = true
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			code = append(code, b...)
+		}
+		if !widened {
+			return code, nil
+		}
+	}
+}
+
+// AssembleHex is Assemble returning the code as a lower-case hex string, the
+// format RunCode and the checker take.
+func AssembleHex(src string) (string, error) {
+	code, err := Assemble(src)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", code), nil
+}
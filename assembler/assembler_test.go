@@ -0,0 +1,145 @@
+package assembler
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"backend/emulator"
+)
+
+// Each source line is written the way the disassembler prints it, so it
+// must assemble to the bytes and disassemble back to the same text.
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		src string
+		hex string
+	}{
+		{"mov rax, 0x5", "48c7c005000000"},
+		{"mov eax, 0x80000000", "b800000080"},
+		{"mov al, 0xff", "b0ff"},
+		{"mov r12, qword ptr [rbx+r13*4+0x10]", "4e8b64ab10"},
+		{"mov dword ptr [rbp-0x8], ecx", "894df8"},
+		{"movabs rax, 0x1122334455667788", "48b88877665544332211"},
+		{"add rax, rbx", "4801d8"},
+		{"add al, 0xff", "04ff"},
+		{"sub rax, -0x80", "4883e880"},
+		{"and eax, 0xffffff00", "2500ffffff"},
+		{"and rax, 0xfffffffffffffff0", "4883e0f0"},
+		{"or r8d, 0x1", "4183c801"},
+		{"xor ax, 0xff00", "663500ff"},
+		{"cmp qword ptr [rsp], 0x7f", "48833c247f"},
+		{"test al, 0xf0", "a8f0"},
+		{"inc qword ptr [rax]", "48ff00"},
+		{"neg ecx", "f7d9"},
+		{"not r9", "49f7d1"},
+		{"shl rax, 0x4", "48c1e004"},
+		{"sar ecx, cl", "d3f9"},
+		{"ror al, 0x1", "d0c8"},
+		{"imul rax, rbx, 0x10", "486bc310"},
+		{"imul ecx, dword ptr [rdx]", "0faf0a"},
+		{"mul rbx", "48f7e3"},
+		{"idiv r10", "49f7fa"},
+		{"cqo", "4899"},
+		{"push r15", "4157"},
+		{"pop rbx", "5b"},
+		{"lea rax, [rip+0x10]", "488d0510000000"},
+		{"xchg rax, rbx", "4893"},
+		{"movzx eax, byte ptr [rsi]", "0fb606"},
+		{"movsx rax, cx", "480fbfc1"},
+		{"movsxd rax, ecx", "4863c1"},
+		{"cmovl rax, rcx", "480f4cc1"},
+		{"sete al", "0f94c0"},
+		{"nop", "90"},
+		{"ret", "c3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got, err := AssembleHex(tt.src)
+			require.NoError(t, err)
+			require.Equal(t, tt.hex, got)
+
+			code, _ := hex.DecodeString(tt.hex)
+			lines, err := emulator.Disassemble(code)
+			require.NoError(t, err)
+			require.Len(t, lines, 1)
+			require.Equal(t, tt.src, lines[0].Intel)
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		line, col int
+		msg       string
+	}{
+		{"unknown instruction", "mov rax, 5\n  frob rax", 2, 3, `unknown instruction "frob"`},
+		{"missing operand", "mov rax", 1, 1, "mov expects 2 operand(s), got 1"},
+		{"bad scale", "mov rax, [rbx+rcx*3]", 1, 19, "scale must be 1, 2, 4 or 8"},
+		{"unclosed address", "mov rax, [rbx", 1, 14, "missing ']'"},
+		{"undefined label", "jmp nowhere", 1, 5, `undefined label "nowhere"`},
+		{"redefined label", "x: nop\nx: nop", 2, 1, `label "x" redefined`},
+		{"register as label", "rax: nop", 1, 1, `register name "rax" cannot be a label`},
+		{"label without rip", "mov rax, [rbx+lbl]\nlbl: nop", 1, 10, `label "lbl" in an address needs rip, as in [rip+lbl]`},
+		{"undefined rip label", "mov rax, [rip+missing]", 1, 10, `undefined label "missing"`},
+		{"immediate too wide", "add al, 0x100", 1, 9, "immediate 256 does not fit in 8 bits"},
+		{"32-bit address", "mov eax, [ebx]", 1, 11, "address register ebx must be 64-bit"},
+		{"no operand size", "mov [rax], 1", 1, 5, "operand size not specified (use byte, word, dword or qword ptr)"},
+		{"loop out of range", "top:\n" + strings.Repeat("nop\n", 200) + "loop top", 202, 6, "loop target out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(tt.src)
+			var aerr *Error
+			require.True(t, errors.As(err, &aerr), "got %v", err)
+			require.Equal(t, Error{Line: tt.line, Col: tt.col, Msg: tt.msg}, *aerr)
+		})
+	}
+}
+
+func TestBranchRelaxation(t *testing.T) {
+	nops := func(n int) string { return strings.Repeat("nop\n", n) }
+	tests := []struct {
+		name   string
+		src    string
+		prefix string
+	}{
+		{"short backward", "top: nop\njmp top", "90ebfd"},
+		{"short forward at the limit", "jmp end\n" + nops(127) + "end: nop", "eb7f"},
+		{"near forward past the limit", "jmp end\n" + nops(128) + "end: nop", "e980000000"},
+		{"near conditional", "jz end\n" + nops(200) + "end: nop", "0f84c8000000"},
+		{"near backward", "top: " + nops(130) + "jne top", strings.Repeat("90", 130) + "0f8578ffffff"},
+		// widening the jz pushes mid out of the jmp's rel8 range
+		{"cascading", "jmp mid\njz far\n" + nops(125) + "mid: nop\n" + nops(200) + "far: nop", "e9830000000f84"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AssembleHex(tt.src)
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(got, tt.prefix), "got %s", got)
+		})
+	}
+}
+
+func TestRIPRelativeLabel(t *testing.T) {
+	code, err := Assemble(`
+        mov r12, [rip+data]
+        cmp byte ptr [rip+data+2], 0x10
+        jmp end
+data:   movabs rax, 0x1122334455667788
+        nop
+end:    mov rax, [rip+data+2]`)
+	require.NoError(t, err)
+	// the disp is taken from the end of the instruction, after any immediate
+	require.Equal(t, "4c8b2509000000"+"803d0400000010"+"eb0b"+"48b88877665544332211"+"90"+"488b05f0ffffff", hex.EncodeToString(code))
+
+	cpu := emulator.NewCPU(emulator.DefaultConfig())
+	require.NoError(t, cpu.Run(code))
+	require.Equal(t, int64(0x334455667788b848), cpu.GetRegister(emulator.R12))
+	require.Equal(t, int64(0x1122334455667788), cpu.GetRegister(emulator.RAX))
+}
@@ -0,0 +1,925 @@
+package assembler
+
+import (
+	"errors"
+	"math"
+	"strings"
+
+	"backend/emulator"
+)
+
+// errShortRange asks the caller to retry a branch with its rel32 form.
+var errShortRange = errors.New("short branch out of range")
+
+// encoding collects the parts of one instruction in the order they are
+// emitted: [66] [REX] opcode [ModRM [SIB] [disp]] [imm].
+type encoding struct {
+	prefix66 bool
+	rexW     bool
+	rexR     bool
+	rexX     bool
+	rexB     bool
+	needRex  bool // SPL, BPL, SIL or DIL
+	noRex    bool // AH, CH, DH or BH
+
+	opcode   []byte
+	hasModRM bool
+	modrm    byte
+	hasSIB   bool
+	sib      byte
+	disp     []byte
+	imm      []byte
+
+	ripLabel  string // label the rip-relative disp points at, plus labelDisp
+	labelDisp int64
+	labelCol  int
+}
+
+// newEncoding starts an instruction with the given operand size. Pass 0 for
+// instructions that default to 64-bit operands and need no REX.W.
+func newEncoding(size int, opcode ...byte) *encoding {
+	return &encoding{prefix66: size == 2, rexW: size == 8, opcode: opcode}
+}
+
+func (e *encoding) byteReg(op operand) {
+	if op.kind != opReg || op.size != 1 {
+		return
+	}
+	if op.high {
+		e.noRex = true
+	} else if op.reg >= emulator.RSP && op.reg <= emulator.RDI {
+		e.needRex = true
+	}
+}
+
+// plusReg adds a register to the low three bits of the last opcode byte, as
+// in PUSH r64 (50+r) and MOV r, imm (B8+r).
+func (e *encoding) plusReg(op operand) {
+	e.opcode[len(e.opcode)-1] += byte(op.reg & 7)
+	e.rexB = op.reg >= 8
+	e.byteReg(op)
+}
+
+func (e *encoding) regField(op operand) {
+	e.hasModRM = true
+	e.modrm |= byte(op.reg&7) << 3
+	e.rexR = op.reg >= 8
+	e.byteReg(op)
+}
+
+func (e *encoding) digit(d byte) {
+	e.hasModRM = true
+	e.modrm |= d << 3
+}
+
+func (e *encoding) rmField(s *statement, op operand) error {
+	e.hasModRM = true
+	if op.kind == opReg {
+		e.modrm |= 0xC0 | byte(op.reg&7)
+		e.rexB = op.reg >= 8
+		e.byteReg(op)
+		return nil
+	}
+
+	if op.disp < math.MinInt32 || op.disp > math.MaxInt32 {
+		return s.errorf(op.col, "displacement %d out of range", op.disp)
+	}
+	disp := int32(op.disp)
+	scaleBits := map[int]byte{1: 0, 2: 1, 4: 2, 8: 3}[op.scale]
+
+	switch {
+	case op.rip:
+		e.modrm |= 0x05
+		e.disp = le(int64(disp), 4)
+		e.ripLabel, e.labelDisp, e.labelCol = op.label, op.disp, op.col
+	case !op.hasBase:
+		// SIB with no base register always carries a disp32
+		index := byte(4)
+		if op.hasIndex {
+			index = byte(op.index & 7)
+			e.rexX = op.index >= 8
+		}
+		e.modrm |= 0x04
+		e.hasSIB = true
+		e.sib = scaleBits<<6 | index<<3 | 0x05
+		e.disp = le(int64(disp), 4)
+	default:
+		base := byte(op.base & 7)
+		e.rexB = op.base >= 8
+		if op.hasIndex || base == 4 {
+			index := byte(4)
+			if op.hasIndex {
+				index = byte(op.index & 7)
+				e.rexX = op.index >= 8
+			}
+			e.modrm |= 0x04
+			e.hasSIB = true
+			e.sib = scaleBits<<6 | index<<3 | base
+		} else {
+			e.modrm |= base
+		}
+		// RBP and R13 as base cannot use mod 00, which means disp32/RIP there
+		switch {
+		case disp == 0 && base != 5:
+		case disp >= math.MinInt8 && disp <= math.MaxInt8:
+			e.modrm |= 0x40
+			e.disp = le(int64(disp), 1)
+		default:
+			e.modrm |= 0x80
+			e.disp = le(int64(disp), 4)
+		}
+	}
+	return nil
+}
+
+func (e *encoding) bytes(s *statement) ([]byte, error) {
+	var out []byte
+	if e.prefix66 {
+		out = append(out, 0x66)
+	}
+	rex := byte(0x40)
+	for i, bit := range []bool{e.rexB, e.rexX, e.rexR, e.rexW} {
+		if bit {
+			rex |= 1 << i
+		}
+	}
+	if rex != 0x40 || e.needRex {
+		if e.noRex {
+			return nil, s.errorf(s.col, "ah, ch, dh and bh cannot be used with an instruction that needs a REX prefix")
+		}
+		out = append(out, rex)
+	}
+	out = append(out, e.opcode...)
+	if e.hasModRM {
+		out = append(out, e.modrm)
+	}
+	if e.hasSIB {
+		out = append(out, e.sib)
+	}
+	dispAt := len(out)
+	out = append(out, e.disp...)
+	out = append(out, e.imm...)
+
+	if e.ripLabel != "" {
+		// rip is the address of the next instruction, so the length has to
+		// be known first; it does not depend on the label
+		target := s.addr
+		if s.labels != nil {
+			t, ok := s.labels[e.ripLabel]
+			if !ok {
+				return nil, s.errorf(e.labelCol, "undefined label %q", e.ripLabel)
+			}
+			target = t
+		}
+		disp := int64(target) + e.labelDisp - int64(s.addr+len(out))
+		if !fitsInt32(disp) {
+			return nil, s.errorf(e.labelCol, "displacement %d out of range", disp)
+		}
+		copy(out[dispAt:], le(disp, 4))
+	}
+	return out, nil
+}
+
+func le(v int64, n int) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(v >> (8 * i))
+	}
+	return out
+}
+
+// immBytes encodes an n-byte immediate for an operand of opSize bytes. A
+// full-width immediate may be written signed or unsigned; a narrower one is
+// sign-extended by the CPU and so must fit as a signed value.
+func (s *statement) immBytes(op operand, opSize, n int) ([]byte, error) {
+	if n < 8 {
+		lo := -(int64(1) << (n*8 - 1))
+		hi := int64(1)<<(n*8-1) - 1
+		if n >= opSize {
+			hi = int64(1)<<(n*8) - 1
+		}
+		if op.imm < lo || op.imm > hi {
+			return nil, s.errorf(op.col, "immediate %d does not fit in %d bits", op.imm, n*8)
+		}
+	}
+	return le(op.imm, n), nil
+}
+
+func fitsInt8(v int64) bool {
+	return v >= math.MinInt8 && v <= math.MaxInt8
+}
+
+func fitsInt32(v int64) bool {
+	return v >= math.MinInt32 && v <= math.MaxInt32
+}
+
+// immZ is the width of an iz immediate: 2 bytes for 16-bit operands and 4
+// (sign-extended for 64-bit operands) otherwise.
+func immZ(size int) int {
+	if size == 2 {
+		return 2
+	}
+	if size == 1 {
+		return 1
+	}
+	return 4
+}
+
+// w picks the byte or the word/dword/qword opcode of a pair.
+func w(opcode byte, size int) byte {
+	if size == 1 {
+		return opcode
+	}
+	return opcode + 1
+}
+
+func isRM(op operand) bool {
+	return op.kind == opReg || op.kind == opMem
+}
+
+func isAccumulator(op operand) bool {
+	return op.kind == opReg && op.reg == emulator.RAX && !op.high
+}
+
+func (s *statement) want(n int) error {
+	if len(s.operands) != n {
+		return s.errorf(s.col, "%s expects %d operand(s), got %d", s.mnemonic, n, len(s.operands))
+	}
+	return nil
+}
+
+func (s *statement) invalid() error {
+	return s.errorf(s.col, "invalid operands for %s", s.mnemonic)
+}
+
+// size returns the operand size shared by the register and sized memory
+// operands in ops.
+func (s *statement) size(ops ...operand) (int, error) {
+	size := 0
+	for _, op := range ops {
+		if !isRM(op) || op.size == 0 {
+			continue
+		}
+		if size != 0 && op.size != size {
+			return 0, s.errorf(op.col, "operand size mismatch")
+		}
+		size = op.size
+	}
+	if size == 0 {
+		return 0, s.errorf(ops[0].col, "operand size not specified (use byte, word, dword or qword ptr)")
+	}
+	return size, nil
+}
+
+var aluDigits = map[string]byte{"add": 0, "or": 1, "and": 4, "sub": 5, "xor": 6, "cmp": 7}
+
+var shiftDigits = map[string]byte{"rol": 0, "ror": 1, "shl": 4, "sal": 4, "shr": 5, "sar": 7}
+
+var unaryDigits = map[string]byte{"not": 2, "neg": 3, "mul": 4, "div": 6, "idiv": 7}
+
+var conditions = map[string]byte{
+	"o": 0x0, "no": 0x1,
+	"b": 0x2, "c": 0x2, "nae": 0x2,
+	"ae": 0x3, "nb": 0x3, "nc": 0x3,
+	"e": 0x4, "z": 0x4,
+	"ne": 0x5, "nz": 0x5,
+	"be": 0x6, "na": 0x6,
+	"a": 0x7, "nbe": 0x7,
+	"s": 0x8, "ns": 0x9,
+	"p": 0xA, "pe": 0xA,
+	"np": 0xB, "po": 0xB,
+	"l": 0xC, "nge": 0xC,
+	"ge": 0xD, "nl": 0xD,
+	"le": 0xE, "ng": 0xE,
+	"g": 0xF, "nle": 0xF,
+}
+
+func condition(mnemonic, prefix string) (byte, bool) {
+	if !strings.HasPrefix(mnemonic, prefix) {
+		return 0, false
+	}
+	cc, ok := conditions[mnemonic[len(prefix):]]
+	return cc, ok
+}
+
+// encode assembles s at addr. labels maps names to addresses; during layout
+// it is nil and every label resolves to addr. long selects the rel32 form of
+// branches that also have a rel8 form.
+func encode(s *statement, addr int, labels map[string]int, long bool) ([]byte, error) {
+	s.addr, s.labels = addr, labels
+	m := s.mnemonic
+	if d, ok := aluDigits[m]; ok {
+		return s.encodeALU(d)
+	}
+	if d, ok := shiftDigits[m]; ok {
+		return s.encodeShift(d)
+	}
+	if d, ok := unaryDigits[m]; ok {
+		return s.encodeUnary(d)
+	}
+	if cc, ok := condition(m, "cmov"); ok {
+		return s.encodeCmov(cc)
+	}
+	if cc, ok := condition(m, "set"); ok {
+		return s.encodeSet(cc)
+	}
+	if cc, ok := condition(m, "j"); ok {
+		return s.encodeBranch(addr, labels, long, []byte{0x70 + cc}, []byte{0x0F, 0x80 + cc})
+	}
+
+	switch m {
+	case "nop", "ret", "cwd", "cdq", "cqo":
+		if err := s.want(0); err != nil {
+			return nil, err
+		}
+		return map[string][]byte{
+			"nop": {0x90},
+			"ret": {0xC3},
+			"cwd": {0x66, 0x99},
+			"cdq": {0x99},
+			"cqo": {0x48, 0x99},
+		}[m], nil
+	case "mov":
+		return s.encodeMov()
+	case "movabs":
+		return s.encodeMovabs()
+	case "test":
+		return s.encodeTest()
+	case "push":
+		return s.encodePush()
+	case "pop":
+		return s.encodePop()
+	case "inc":
+		return s.encodeUnary(0)
+	case "dec":
+		return s.encodeUnary(1)
+	case "imul":
+		return s.encodeImul()
+	case "lea":
+		return s.encodeLea()
+	case "xchg":
+		return s.encodeXchg()
+	case "movzx":
+		return s.encodeExtend(0xB6)
+	case "movsx":
+		return s.encodeExtend(0xBE)
+	case "movsxd":
+		return s.encodeMovsxd()
+	case "jmp":
+		if err := s.want(1); err == nil && isRM(s.operands[0]) {
+			return s.encodeIndirect(4)
+		}
+		return s.encodeBranch(addr, labels, long, []byte{0xEB}, []byte{0xE9})
+	case "call":
+		if err := s.want(1); err == nil && isRM(s.operands[0]) {
+			return s.encodeIndirect(2)
+		}
+		return s.encodeBranch(addr, labels, true, nil, []byte{0xE8})
+	case "loop":
+		return s.encodeBranch(addr, labels, false, []byte{0xE2}, nil)
+	}
+	return nil, s.errorf(s.col, "unknown instruction %q", s.mnemonic)
+}
+
+func (s *statement) encodeALU(digit byte) ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	dst, src := s.operands[0], s.operands[1]
+
+	switch {
+	case isRM(dst) && src.kind == opImm:
+		size, err := s.size(dst)
+		if err != nil {
+			return nil, err
+		}
+		// an immediate written unsigned, as the disassembler prints logical
+		// ops, picks the same encoding as its signed value
+		if size == 2 || size == 4 {
+			if bits := uint(size * 8); src.imm >= 1<<(bits-1) && src.imm < 1<<bits {
+				src.imm -= 1 << bits
+			}
+		}
+		var e *encoding
+		var n int
+		switch {
+		case size == 1 && isAccumulator(dst):
+			e, n = newEncoding(size, digit<<3|0x04), 1
+		case size == 1:
+			e, n = newEncoding(size, 0x80), 1
+		case fitsInt8(src.imm):
+			e, n = newEncoding(size, 0x83), 1
+		case isAccumulator(dst):
+			e, n = newEncoding(size, digit<<3|0x05), immZ(size)
+		default:
+			e, n = newEncoding(size, 0x81), immZ(size)
+		}
+		if e.opcode[0] >= 0x80 {
+			e.digit(digit)
+			if err := e.rmField(s, dst); err != nil {
+				return nil, err
+			}
+		}
+		if e.imm, err = s.immBytes(src, size, n); err != nil {
+			return nil, err
+		}
+		return e.bytes(s)
+
+	case isRM(dst) && src.kind == opReg:
+		return s.encodeRM(digit<<3, dst, src)
+
+	case dst.kind == opReg && src.kind == opMem:
+		return s.encodeRM(digit<<3|0x02, src, dst)
+	}
+	return nil, s.invalid()
+}
+
+// encodeRM emits the common "opcode /r" shape where the operand size is
+// shared by rm and reg and the byte form is opcode, the wider one opcode+1.
+func (s *statement) encodeRM(opcode byte, rm, reg operand) ([]byte, error) {
+	size, err := s.size(rm, reg)
+	if err != nil {
+		return nil, err
+	}
+	e := newEncoding(size, w(opcode, size))
+	e.regField(reg)
+	if err := e.rmField(s, rm); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+func (s *statement) encodeMov() ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	dst, src := s.operands[0], s.operands[1]
+
+	switch {
+	case dst.kind == opReg && src.kind == opImm:
+		var e *encoding
+		var n int
+		switch {
+		case dst.size == 1:
+			e, n = newEncoding(1, 0xB0), 1
+		case dst.size == 8 && fitsInt32(src.imm):
+			// REX.W C7 /0 sign-extends an imm32, which is what genhex emits
+			e := newEncoding(8, 0xC7)
+			if err := e.rmField(s, dst); err != nil {
+				return nil, err
+			}
+			e.imm = le(src.imm, 4)
+			return e.bytes(s)
+		default:
+			e, n = newEncoding(dst.size, 0xB8), dst.size
+		}
+		e.plusReg(dst)
+		var err error
+		if e.imm, err = s.immBytes(src, dst.size, n); err != nil {
+			return nil, err
+		}
+		return e.bytes(s)
+
+	case dst.kind == opMem && src.kind == opImm:
+		size, err := s.size(dst)
+		if err != nil {
+			return nil, err
+		}
+		e := newEncoding(size, w(0xC6, size))
+		if err := e.rmField(s, dst); err != nil {
+			return nil, err
+		}
+		if e.imm, err = s.immBytes(src, size, immZ(size)); err != nil {
+			return nil, err
+		}
+		return e.bytes(s)
+
+	case isRM(dst) && src.kind == opReg:
+		return s.encodeRM(0x88, dst, src)
+
+	case dst.kind == opReg && src.kind == opMem:
+		return s.encodeRM(0x8A, src, dst)
+	}
+	return nil, s.invalid()
+}
+
+func (s *statement) encodeMovabs() ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	dst, src := s.operands[0], s.operands[1]
+	if dst.kind != opReg || dst.size != 8 || src.kind != opImm {
+		return nil, s.invalid()
+	}
+	e := newEncoding(8, 0xB8)
+	e.plusReg(dst)
+	e.imm = le(src.imm, 8)
+	return e.bytes(s)
+}
+
+func (s *statement) encodeTest() ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	a, b := s.operands[0], s.operands[1]
+
+	switch {
+	case isRM(a) && b.kind == opImm:
+		size, err := s.size(a)
+		if err != nil {
+			return nil, err
+		}
+		var e *encoding
+		if isAccumulator(a) {
+			e = newEncoding(size, w(0xA8, size))
+		} else {
+			e = newEncoding(size, w(0xF6, size))
+			e.digit(0)
+			if err := e.rmField(s, a); err != nil {
+				return nil, err
+			}
+		}
+		if e.imm, err = s.immBytes(b, size, immZ(size)); err != nil {
+			return nil, err
+		}
+		return e.bytes(s)
+
+	case isRM(a) && b.kind == opReg:
+		return s.encodeRM(0x84, a, b)
+
+	case a.kind == opReg && b.kind == opMem:
+		// TEST is commutative, so the memory operand goes in r/m
+		return s.encodeRM(0x84, b, a)
+	}
+	return nil, s.invalid()
+}
+
+// stackSize maps a PUSH/POP operand size to the newEncoding size: 64-bit is
+// the default and 16-bit needs the 0x66 prefix.
+func (s *statement) stackSize(op operand) (int, error) {
+	switch op.size {
+	case 0, 8:
+		return 0, nil
+	case 2:
+		return 2, nil
+	}
+	return 0, s.errorf(op.col, "%s operand must be 16 or 64 bits", s.mnemonic)
+}
+
+func (s *statement) encodePush() ([]byte, error) {
+	if err := s.want(1); err != nil {
+		return nil, err
+	}
+	op := s.operands[0]
+
+	if op.kind == opImm {
+		var e *encoding
+		var n int
+		if fitsInt8(op.imm) {
+			e, n = newEncoding(0, 0x6A), 1
+		} else {
+			e, n = newEncoding(0, 0x68), 4
+		}
+		var err error
+		if e.imm, err = s.immBytes(op, 8, n); err != nil {
+			return nil, err
+		}
+		return e.bytes(s)
+	}
+	if !isRM(op) {
+		return nil, s.invalid()
+	}
+
+	size, err := s.stackSize(op)
+	if err != nil {
+		return nil, err
+	}
+	if op.kind == opReg {
+		e := newEncoding(size, 0x50)
+		e.plusReg(op)
+		return e.bytes(s)
+	}
+	e := newEncoding(size, 0xFF)
+	e.digit(6)
+	if err := e.rmField(s, op); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+func (s *statement) encodePop() ([]byte, error) {
+	if err := s.want(1); err != nil {
+		return nil, err
+	}
+	op := s.operands[0]
+	if !isRM(op) {
+		return nil, s.invalid()
+	}
+
+	size, err := s.stackSize(op)
+	if err != nil {
+		return nil, err
+	}
+	if op.kind == opReg {
+		e := newEncoding(size, 0x58)
+		e.plusReg(op)
+		return e.bytes(s)
+	}
+	e := newEncoding(size, 0x8F)
+	e.digit(0)
+	if err := e.rmField(s, op); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+// encodeUnary covers the one-operand F6/F7 group (NOT, NEG, MUL, DIV, IDIV)
+// and, for digits 0 and 1, INC and DEC from FE/FF.
+func (s *statement) encodeUnary(digit byte) ([]byte, error) {
+	if err := s.want(1); err != nil {
+		return nil, err
+	}
+	op := s.operands[0]
+	if !isRM(op) {
+		return nil, s.invalid()
+	}
+	size, err := s.size(op)
+	if err != nil {
+		return nil, err
+	}
+
+	opcode := byte(0xF6)
+	if digit <= 1 {
+		opcode = 0xFE
+	}
+	e := newEncoding(size, w(opcode, size))
+	e.digit(digit)
+	if err := e.rmField(s, op); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+func (s *statement) encodeImul() ([]byte, error) {
+	ops := s.operands
+	switch len(ops) {
+	case 1:
+		return s.encodeUnary(5)
+
+	case 2:
+		if ops[0].kind != opReg || !isRM(ops[1]) {
+			return nil, s.invalid()
+		}
+		size, err := s.size(ops[0], ops[1])
+		if err != nil {
+			return nil, err
+		}
+		if size == 1 {
+			return nil, s.errorf(ops[0].col, "imul does not take 8-bit registers here")
+		}
+		e := newEncoding(size, 0x0F, 0xAF)
+		e.regField(ops[0])
+		if err := e.rmField(s, ops[1]); err != nil {
+			return nil, err
+		}
+		return e.bytes(s)
+
+	case 3:
+		if ops[0].kind != opReg || !isRM(ops[1]) || ops[2].kind != opImm {
+			return nil, s.invalid()
+		}
+		size, err := s.size(ops[0], ops[1])
+		if err != nil {
+			return nil, err
+		}
+		if size == 1 {
+			return nil, s.errorf(ops[0].col, "imul does not take 8-bit registers here")
+		}
+		var e *encoding
+		var n int
+		if fitsInt8(ops[2].imm) {
+			e, n = newEncoding(size, 0x6B), 1
+		} else {
+			e, n = newEncoding(size, 0x69), immZ(size)
+		}
+		e.regField(ops[0])
+		if err := e.rmField(s, ops[1]); err != nil {
+			return nil, err
+		}
+		if e.imm, err = s.immBytes(ops[2], size, n); err != nil {
+			return nil, err
+		}
+		return e.bytes(s)
+	}
+	return nil, s.errorf(s.col, "imul expects 1 to 3 operands, got %d", len(ops))
+}
+
+func (s *statement) encodeShift(digit byte) ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	dst, count := s.operands[0], s.operands[1]
+	if !isRM(dst) {
+		return nil, s.invalid()
+	}
+	size, err := s.size(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	var e *encoding
+	switch {
+	case count.kind == opImm && count.imm == 1:
+		e = newEncoding(size, w(0xD0, size))
+	case count.kind == opImm:
+		e = newEncoding(size, w(0xC0, size))
+		if e.imm, err = s.immBytes(count, 1, 1); err != nil {
+			return nil, err
+		}
+	case count.kind == opReg && count.reg == emulator.RCX && count.size == 1:
+		e = newEncoding(size, w(0xD2, size))
+	default:
+		return nil, s.errorf(count.col, "shift count must be an immediate or cl")
+	}
+	e.digit(digit)
+	if err := e.rmField(s, dst); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+func (s *statement) encodeLea() ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	dst, src := s.operands[0], s.operands[1]
+	if dst.kind != opReg || src.kind != opMem || dst.size == 1 {
+		return nil, s.invalid()
+	}
+	e := newEncoding(dst.size, 0x8D)
+	e.regField(dst)
+	if err := e.rmField(s, src); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+func (s *statement) encodeXchg() ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	a, b := s.operands[0], s.operands[1]
+	if a.kind == opReg && b.kind == opMem || isAccumulator(b) && a.kind == opReg {
+		a, b = b, a
+	}
+	if !isRM(a) || b.kind != opReg {
+		return nil, s.invalid()
+	}
+
+	// 90+r with the accumulator, except xchg eax, eax: 90 is NOP and must
+	// not be used where the upper half of RAX would be cleared
+	if isAccumulator(a) && a.size != 1 && !(b.reg == emulator.RAX && a.size == 4) {
+		size, err := s.size(a, b)
+		if err != nil {
+			return nil, err
+		}
+		e := newEncoding(size, 0x90)
+		e.plusReg(b)
+		return e.bytes(s)
+	}
+	return s.encodeRM(0x86, a, b)
+}
+
+// encodeExtend emits MOVZX (0F B6/B7) and MOVSX (0F BE/BF).
+func (s *statement) encodeExtend(opcode byte) ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	dst, src := s.operands[0], s.operands[1]
+	if dst.kind != opReg || !isRM(src) || dst.size == 1 {
+		return nil, s.invalid()
+	}
+	if src.size == 0 {
+		return nil, s.errorf(src.col, "operand size not specified (use byte or word ptr)")
+	}
+	if src.size >= dst.size || src.size > 2 {
+		return nil, s.errorf(src.col, "source must be narrower than the destination and at most 16 bits")
+	}
+	e := newEncoding(dst.size, 0x0F, w(opcode, src.size))
+	e.regField(dst)
+	if err := e.rmField(s, src); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+func (s *statement) encodeMovsxd() ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	dst, src := s.operands[0], s.operands[1]
+	if dst.kind != opReg || dst.size != 8 || !isRM(src) || (src.size != 0 && src.size != 4) {
+		return nil, s.invalid()
+	}
+	e := newEncoding(8, 0x63)
+	e.regField(dst)
+	if err := e.rmField(s, src); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+func (s *statement) encodeCmov(cc byte) ([]byte, error) {
+	if err := s.want(2); err != nil {
+		return nil, err
+	}
+	dst, src := s.operands[0], s.operands[1]
+	if dst.kind != opReg || !isRM(src) || dst.size == 1 {
+		return nil, s.invalid()
+	}
+	size, err := s.size(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	e := newEncoding(size, 0x0F, 0x40+cc)
+	e.regField(dst)
+	if err := e.rmField(s, src); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+func (s *statement) encodeSet(cc byte) ([]byte, error) {
+	if err := s.want(1); err != nil {
+		return nil, err
+	}
+	op := s.operands[0]
+	if !isRM(op) || (op.size != 0 && op.size != 1) {
+		return nil, s.invalid()
+	}
+	e := newEncoding(1, 0x0F, 0x90+cc)
+	e.digit(0)
+	if err := e.rmField(s, op); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+// encodeIndirect emits CALL (/2) and JMP (/4) through a 64-bit register or
+// memory operand.
+func (s *statement) encodeIndirect(digit byte) ([]byte, error) {
+	op := s.operands[0]
+	if op.size != 0 && op.size != 8 {
+		return nil, s.errorf(op.col, "%s target must be 64-bit", s.mnemonic)
+	}
+	e := newEncoding(0, 0xFF)
+	e.digit(digit)
+	if err := e.rmField(s, op); err != nil {
+		return nil, err
+	}
+	return e.bytes(s)
+}
+
+// encodeBranch emits a relative branch. A numeric operand is an absolute
+// offset into the code, as printed by the disassembler. short or long may be
+// nil when the instruction has only one form.
+func (s *statement) encodeBranch(addr int, labels map[string]int, long bool, short, near []byte) ([]byte, error) {
+	if err := s.want(1); err != nil {
+		return nil, err
+	}
+	op := s.operands[0]
+
+	var target int
+	switch op.kind {
+	case opImm:
+		target = int(op.imm)
+	case opLabel:
+		if labels == nil {
+			target = addr
+			break
+		}
+		t, ok := labels[op.label]
+		if !ok {
+			return nil, s.errorf(op.col, "undefined label %q", op.label)
+		}
+		target = t
+	default:
+		return nil, s.errorf(op.col, "expected a label or offset")
+	}
+
+	if !long && short != nil {
+		rel := target - (addr + len(short) + 1)
+		if fitsInt8(int64(rel)) {
+			return append(append([]byte{}, short...), byte(rel)), nil
+		}
+		if near == nil {
+			return nil, s.errorf(op.col, "%s target out of range", s.mnemonic)
+		}
+		return nil, errShortRange
+	}
+	rel := target - (addr + len(near) + 4)
+	if !fitsInt32(int64(rel)) {
+		return nil, s.errorf(op.col, "%s target out of range", s.mnemonic)
+	}
+	return append(append([]byte{}, near...), le(int64(rel), 4)...), nil
+}
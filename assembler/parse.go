@@ -0,0 +1,394 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"backend/emulator"
+)
+
+// Error reports a problem in the source together with its 1-based line and
+// column.
+type Error struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	col  int
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// tokenize splits one source line. Everything after ';' or '#' is a comment.
+func tokenize(line string, lineNo int) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ';' || c == '#':
+			return tokens, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(line) && isIdentChar(line[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: line[start:i], col: start + 1})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(line) && isIdentChar(line[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: line[start:i], col: start + 1})
+		case strings.IndexByte(",[]+-*:", c) >= 0:
+			tokens = append(tokens, token{kind: tokPunct, text: line[i : i+1], col: i + 1})
+			i++
+		default:
+			return nil, &Error{Line: lineNo, Col: i + 1, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return tokens, nil
+}
+
+// parseNumber accepts decimal, 0x hexadecimal and 0b binary literals.
+func parseNumber(text string) (int64, error) {
+	s := strings.ToLower(text)
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "0x"):
+		s, base = s[2:], 16
+	case strings.HasPrefix(s, "0b"):
+		s, base = s[2:], 2
+	}
+	v, err := strconv.ParseUint(s, base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", text)
+	}
+	return int64(v), nil
+}
+
+type regInfo struct {
+	reg  emulator.Register
+	size int
+	high bool // AH, CH, DH or BH
+}
+
+var registers = map[string]regInfo{}
+
+func init() {
+	for r := emulator.Register(0); r < emulator.NumRegisters; r++ {
+		for _, size := range []int{1, 2, 4, 8} {
+			registers[emulator.RegisterName(r, size, true)] = regInfo{reg: r, size: size}
+		}
+	}
+	for r := emulator.RSP; r <= emulator.RDI; r++ {
+		registers[emulator.RegisterName(r, 1, false)] = regInfo{reg: r, size: 1, high: true}
+	}
+}
+
+var ptrSizes = map[string]int{"byte": 1, "word": 2, "dword": 4, "qword": 8}
+
+type operandKind int
+
+const (
+	opReg operandKind = iota
+	opMem
+	opImm
+	opLabel
+)
+
+type operand struct {
+	kind operandKind
+	col  int
+	size int // register size, or the ptr size of a memory operand (0 if none)
+
+	reg  emulator.Register
+	high bool
+
+	base     emulator.Register
+	index    emulator.Register
+	hasBase  bool
+	hasIndex bool
+	rip      bool
+	scale    int
+	disp     int64
+
+	imm   int64
+	label string // branch target, or the label a rip-relative address points at
+}
+
+type statement struct {
+	line     int
+	col      int
+	mnemonic string
+	operands []operand
+
+	// where encode is placing the statement, for rip-relative labels
+	addr   int
+	labels map[string]int
+}
+
+func (s *statement) errorf(col int, format string, args ...interface{}) error {
+	return &Error{Line: s.line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parser walks the tokens of a single line.
+type parser struct {
+	line   int
+	tokens []token
+	pos    int
+	eolCol int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (token, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+func (p *parser) isPunct(text string) bool {
+	t, ok := p.peek()
+	return ok && t.kind == tokPunct && t.text == text
+}
+
+func (p *parser) col() int {
+	if t, ok := p.peek(); ok {
+		return t.col
+	}
+	return p.eolCol
+}
+
+func (p *parser) errorf(col int, format string, args ...interface{}) error {
+	return &Error{Line: p.line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expect(text string) error {
+	if !p.isPunct(text) {
+		return p.errorf(p.col(), "expected %q", text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	t, ok := p.next()
+	if !ok {
+		return operand{}, p.errorf(p.eolCol, "missing operand")
+	}
+
+	switch {
+	case t.kind == tokPunct && t.text == "[":
+		return p.parseMemory(t.col, 0)
+	case t.kind == tokPunct && t.text == "-":
+		n, ok := p.next()
+		if !ok || n.kind != tokNumber {
+			return operand{}, p.errorf(t.col, "expected number after '-'")
+		}
+		v, err := parseNumber(n.text)
+		if err != nil {
+			return operand{}, p.errorf(n.col, "%v", err)
+		}
+		return operand{kind: opImm, col: t.col, imm: -v}, nil
+	case t.kind == tokNumber:
+		v, err := parseNumber(t.text)
+		if err != nil {
+			return operand{}, p.errorf(t.col, "%v", err)
+		}
+		return operand{kind: opImm, col: t.col, imm: v}, nil
+	case t.kind == tokIdent:
+		name := strings.ToLower(t.text)
+		if r, ok := registers[name]; ok {
+			return operand{kind: opReg, col: t.col, reg: r.reg, size: r.size, high: r.high}, nil
+		}
+		if size, ok := ptrSizes[name]; ok {
+			if n, ok := p.peek(); ok && n.kind == tokIdent && strings.EqualFold(n.text, "ptr") {
+				p.pos++
+			}
+			if err := p.expect("["); err != nil {
+				return operand{}, err
+			}
+			return p.parseMemory(t.col, size)
+		}
+		return operand{kind: opLabel, col: t.col, label: t.text}, nil
+	}
+	return operand{}, p.errorf(t.col, "unexpected %q", t.text)
+}
+
+// parseMemory parses the inside of [...] as a sum of a base register, an
+// index register with an optional *scale, rip and numeric displacements.
+// With rip, a label may stand in for the displacement to its address.
+func (p *parser) parseMemory(col, size int) (operand, error) {
+	op := operand{kind: opMem, col: col, size: size}
+	sign := int64(1)
+	for {
+		t, ok := p.next()
+		if !ok {
+			return op, p.errorf(p.eolCol, "missing ']'")
+		}
+		switch {
+		case t.kind == tokNumber:
+			v, err := parseNumber(t.text)
+			if err != nil {
+				return op, p.errorf(t.col, "%v", err)
+			}
+			op.disp += sign * v
+		case t.kind == tokIdent && strings.EqualFold(t.text, "rip"):
+			if sign < 0 || op.rip || op.hasBase || op.hasIndex {
+				return op, p.errorf(t.col, "rip cannot be combined with other registers")
+			}
+			op.rip = true
+		case t.kind == tokIdent:
+			r, ok := registers[strings.ToLower(t.text)]
+			if !ok {
+				if sign < 0 || op.label != "" {
+					return op, p.errorf(t.col, "invalid use of label %s in address", t.text)
+				}
+				op.label = t.text
+				break
+			}
+			if r.size != 8 {
+				return op, p.errorf(t.col, "address register %s must be 64-bit", t.text)
+			}
+			if sign < 0 || op.rip {
+				return op, p.errorf(t.col, "invalid use of register %s in address", t.text)
+			}
+			scale := 0
+			if p.isPunct("*") {
+				p.pos++
+				n, ok := p.next()
+				if !ok || n.kind != tokNumber {
+					return op, p.errorf(p.col(), "expected scale")
+				}
+				v, _ := parseNumber(n.text)
+				if v != 1 && v != 2 && v != 4 && v != 8 {
+					return op, p.errorf(n.col, "scale must be 1, 2, 4 or 8")
+				}
+				scale = int(v)
+			}
+			switch {
+			case scale == 0 && !op.hasBase:
+				op.base, op.hasBase = r.reg, true
+			case !op.hasIndex:
+				if scale == 0 {
+					scale = 1
+				}
+				op.index, op.hasIndex, op.scale = r.reg, true, scale
+			default:
+				return op, p.errorf(t.col, "too many registers in address")
+			}
+		default:
+			return op, p.errorf(t.col, "unexpected %q in address", t.text)
+		}
+
+		switch {
+		case p.isPunct("]"):
+			p.pos++
+			if op.hasIndex && op.index == emulator.RSP {
+				return op, p.errorf(col, "rsp cannot be used as an index register")
+			}
+			if op.rip && op.hasIndex {
+				return op, p.errorf(col, "rip cannot be combined with other registers")
+			}
+			if op.label != "" && !op.rip {
+				return op, p.errorf(col, "label %q in an address needs rip, as in [rip+%s]", op.label, op.label)
+			}
+			return op, nil
+		case p.isPunct("+"):
+			sign = 1
+		case p.isPunct("-"):
+			sign = -1
+		case p.pos == len(p.tokens):
+			return op, p.errorf(p.eolCol, "missing ']'")
+		default:
+			return op, p.errorf(p.col(), "expected '+', '-' or ']'")
+		}
+		p.pos++
+	}
+}
+
+// parse splits src into statements and records label offsets by statement
+// index; the labels are resolved to addresses during layout.
+func parse(src string) ([]statement, map[string]int, error) {
+	var stmts []statement
+	labels := map[string]int{}
+	for i, line := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		tokens, err := tokenize(line, lineNo)
+		if err != nil {
+			return nil, nil, err
+		}
+		p := &parser{line: lineNo, tokens: tokens, eolCol: len(strings.TrimRight(line, " \t\r")) + 1}
+
+		for len(p.tokens) >= p.pos+2 && p.tokens[p.pos].kind == tokIdent &&
+			p.tokens[p.pos+1].kind == tokPunct && p.tokens[p.pos+1].text == ":" {
+			t := p.tokens[p.pos]
+			if _, ok := registers[strings.ToLower(t.text)]; ok {
+				return nil, nil, p.errorf(t.col, "register name %q cannot be a label", t.text)
+			}
+			if _, dup := labels[t.text]; dup {
+				return nil, nil, p.errorf(t.col, "label %q redefined", t.text)
+			}
+			labels[t.text] = len(stmts)
+			p.pos += 2
+		}
+
+		t, ok := p.next()
+		if !ok {
+			continue
+		}
+		if t.kind != tokIdent {
+			return nil, nil, p.errorf(t.col, "expected instruction, got %q", t.text)
+		}
+		s := statement{line: lineNo, col: t.col, mnemonic: strings.ToLower(t.text)}
+		for p.pos < len(p.tokens) {
+			op, err := p.parseOperand()
+			if err != nil {
+				return nil, nil, err
+			}
+			s.operands = append(s.operands, op)
+			if p.pos < len(p.tokens) {
+				if err := p.expect(","); err != nil {
+					return nil, nil, err
+				}
+				if p.pos == len(p.tokens) {
+					return nil, nil, p.errorf(p.eolCol, "missing operand")
+				}
+			}
+		}
+		stmts = append(stmts, s)
+	}
+	return stmts, labels, nil
+}
@@ -1,11 +1,174 @@
 declare global {
+  interface RunCodeOptions {
+    /** Overflow policy: "error" (default), "wrap" or "wrap32" */
+    overflow?: "error" | "wrap" | "wrap32";
+    /** Maximum number of instructions to execute */
+    maxSteps?: number;
+    /** Stack size in bytes */
+    stackSize?: number;
+    /** Record every executed instruction in the result's trace */
+    trace?: boolean;
+  }
+
+  interface RegisterState {
+    /** rax..r15 as decimal strings */
+    [register: string]: string | number;
+    /** RFLAGS as hex */
+    rflags: string;
+    pc: number;
+  }
+
+  interface MemoryAccess {
+    /** Address as hex */
+    addr: string;
+    size: number;
+    /** Value as hex */
+    value: string;
+    write: boolean;
+  }
+
+  interface TraceStep {
+    step: number;
+    pc: number;
+    /** Instruction bytes as hex */
+    bytes: string;
+    /** Intel syntax text */
+    intel: string;
+    before: RegisterState;
+    after: RegisterState;
+    memory: MemoryAccess[];
+  }
+
+  interface BitField {
+    /** Field name, e.g. "W", "mod", "scale" */
+    name: string;
+    /** Width in bits */
+    width: number;
+    value: number;
+    note: string;
+  }
+
+  interface AnatomyField {
+    /** "prefix", "rex", "opcode", "modrm", "sib", "disp", "imm" or "rel" */
+    kind: string;
+    /** Byte offset within the instruction */
+    offset: number;
+    /** Field bytes as hex */
+    bytes: string;
+    /** Little-endian value as a decimal string */
+    value: string;
+    /** Bit groups of REX, ModRM and SIB */
+    bits: BitField[];
+    note: string;
+  }
+
+  interface DisasmLine {
+    /** Byte offset of the instruction */
+    offset: number;
+    /** Raw instruction bytes as hex */
+    bytes: string;
+    /** Intel syntax text */
+    intel: string;
+    /** AT&T syntax text */
+    att: string;
+    /** Encoding fields for colour-coding the bytes */
+    fields: AnatomyField[];
+  }
+
+  interface MachineState {
+    /** Number of instructions executed */
+    step: number;
+    /** True once the PC has run off the end of the code */
+    done: boolean;
+    /** RAX as int32 hex, like RunCode's value */
+    value: string;
+    registers: RegisterState;
+    memory: Record<string, string>;
+    /** Set when the last operation failed; the state is still valid */
+    error?: string;
+  }
+
+  interface LevelInfo {
+    level: number;
+    title: string;
+    description: string;
+    /** Initial values, immediates and intermediate results fit in this many bits */
+    valueBits: number;
+    registers: string[];
+    /** Allowed forms: "add imm", "sub imm", "add reg", "sub reg" */
+    instructions: string[];
+    minOps: number;
+    /** null when there is no upper bound */
+    maxOps: number | null;
+  }
+
+  interface GenHexOptions {
+    /** Only return puzzles whose difficulty score is at least this */
+    minDifficulty?: number;
+    /** Only return puzzles whose difficulty score is at most this */
+    maxDifficulty?: number;
+  }
+
+  interface Difficulty {
+    /** Weighted sum of the counts below */
+    score: number;
+    ops: number;
+    /** Hex digits that carry or borrow in add/sub */
+    carries: number;
+    signChanges: number;
+    /** Most registers holding a value still needed at one time */
+    liveRegisters: number;
+    /** Longest chain of add/sub the answer depends on */
+    depth: number;
+    negativeImmediates: number;
+  }
+
   interface Window {
     /**
      * Run WASM code with hex string input
      * @param hexInput - Hexadecimal machine code string
+     * @param options - Optional emulator settings
      * @returns Result as hex string or error object
      */
-    RunCode(hexInput: string): string | { error: string };
+    RunCode(hexInput: string, options?: RunCodeOptions): { value: string; flags: string; memory: Record<string, string>; trace?: TraceStep[] } | { error: string; trace?: TraceStep[] };
+
+    /**
+     * Generate a puzzle
+     * @param level - Difficulty level, one of those listed by Levels()
+     * @param seed - Optional seed in 0..2147483647; the same level and seed give the same puzzle
+     * @param options - Optional difficulty band; pass null as seed to keep it random
+     * @returns [spaced hex, compact hex], the seed used and the puzzle's difficulty, or error object
+     */
+    GenHex(level: number, seed?: number | null, options?: GenHexOptions): { value: [string, string]; seed: number; difficulty: Difficulty } | { error: string };
+
+    /** List the puzzle levels GenHex accepts, easiest first */
+    Levels(): LevelInfo[];
+
+    /**
+     * Disassemble hex machine code
+     * @param hexInput - Hexadecimal machine code string
+     * @returns One entry per instruction or error object
+     */
+    Disassemble(hexInput: string): { value: DisasmLine[] } | { error: string };
+
+    /**
+     * Load a program for stepping; replaces any loaded program
+     * @param hexInput - Hexadecimal machine code string
+     * @param options - Optional emulator settings (trace is ignored)
+     */
+    MachineLoad(hexInput: string, options?: RunCodeOptions): MachineState | { error: string };
+
+    /** Execute one instruction of the loaded program */
+    MachineStep(): MachineState | { error: string };
+
+    /** Undo the last executed instruction */
+    MachineStepBack(): MachineState | { error: string };
+
+    /**
+     * Step forwards or backwards until the given number of instructions have executed
+     * @param step - Target step count, e.g. from a timeline slider
+     */
+    MachineSeek(step: number): MachineState | { error: string };
   }
 }
 
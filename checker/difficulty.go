@@ -0,0 +1,141 @@
+package checker
+
+import "backend/levels"
+
+// Difficulty breaks down how hard a program is to evaluate by hand.
+// Arithmetic is looked at the way the answer is shown, as 32-bit hex.
+type Difficulty struct {
+	// Ops counts the add/sub instructions.
+	Ops int
+	// Carries counts the hex digits of add/sub that carry or borrow into
+	// the next one.
+	Carries int
+	// SignChanges counts add/sub whose destination changes sign.
+	SignChanges int
+	// LiveRegisters is the most registers holding a value still needed
+	// for the answer at one time.
+	LiveRegisters int
+	// Depth is the longest chain of add/sub the answer depends on.
+	Depth int
+	// NegativeImmediates counts immediates below zero, which appear in
+	// two's complement.
+	NegativeImmediates int
+	// Score is the sum weighted by DefaultWeights.
+	Score int
+}
+
+// Weights turn a Difficulty into a single score.
+type Weights struct {
+	Ops                int
+	Carries            int
+	SignChanges        int
+	LiveRegisters      int
+	Depth              int
+	NegativeImmediates int
+}
+
+// DefaultWeights are the weights of Difficulty.Score.
+func DefaultWeights() Weights {
+	return Weights{
+		Ops:                2,
+		Carries:            1,
+		SignChanges:        2,
+		LiveRegisters:      3,
+		Depth:              2,
+		NegativeImmediates: 2,
+	}
+}
+
+func (d Difficulty) Weighted(w Weights) int {
+	return d.Ops*w.Ops +
+		d.Carries*w.Carries +
+		d.SignChanges*w.SignChanges +
+		d.LiveRegisters*w.LiveRegisters +
+		d.Depth*w.Depth +
+		d.NegativeImmediates*w.NegativeImmediates
+}
+
+// nibbleCarries counts the carries (or borrows for sub) out of each of the
+// low seven hex digits of the 32-bit add or subtract a op b.
+func nibbleCarries(a, b uint32, sub bool) int {
+	n, carry := 0, uint32(0)
+	for i := 0; i < 7; i++ {
+		x, y := a>>(4*i)&0xF, b>>(4*i)&0xF
+		if sub {
+			carry = boolBit(x < y+carry)
+		} else {
+			carry = (x + y + carry) >> 4
+		}
+		n += int(carry)
+	}
+	return n
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Difficulty measures the analyzed program.
+func (r *Report) Difficulty() Difficulty {
+	d := Difficulty{Ops: r.Ops}
+	values := map[string]int64{}
+	depth := map[string]int{}
+
+	for _, step := range r.Steps {
+		if step.Src == "" && step.Imm < 0 {
+			d.NegativeImmediates++
+		}
+		if !step.IsOp() {
+			depth[step.Dst] = 0
+			if step.Form == MovReg {
+				depth[step.Dst] = depth[step.Src]
+			}
+			values[step.Dst] = step.Result
+			continue
+		}
+
+		before := values[step.Dst]
+		operand := step.Imm
+		if step.Src != "" {
+			operand = values[step.Src]
+		}
+		sub := step.Form == levels.SubImm || step.Form == levels.SubReg
+		d.Carries += nibbleCarries(uint32(before), uint32(operand), sub)
+		if (before < 0) != (step.Result < 0) {
+			d.SignChanges++
+		}
+
+		switch {
+		case step.Overwrites():
+			depth[step.Dst] = 0
+		case step.Src != "":
+			depth[step.Dst] = max(depth[step.Dst], depth[step.Src]) + 1
+		default:
+			depth[step.Dst]++
+		}
+		values[step.Dst] = step.Result
+	}
+	d.Depth = depth["rax"]
+
+	live := map[string]bool{"rax": true}
+	d.LiveRegisters = 1
+	for i := len(r.Steps) - 1; i >= 0; i-- {
+		step := r.Steps[i]
+		if !live[step.Dst] {
+			continue
+		}
+		if step.Overwrites() {
+			delete(live, step.Dst)
+		}
+		if src := step.Reads(); src != "" {
+			live[src] = true
+		}
+		d.LiveRegisters = max(d.LiveRegisters, len(live))
+	}
+
+	d.Score = d.Weighted(DefaultWeights())
+	return d
+}
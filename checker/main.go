@@ -1,100 +1,312 @@
 package checker
 
-import "encoding/hex"
+import (
+	"errors"
+	"fmt"
+	"strings"
 
+	"backend/emulator"
+	"backend/levels"
+)
+
+// Forms that set a register without counting as an add/sub. Every level
+// allows them.
+const (
+	// MovImm is mov reg, imm, which loads a register.
+	MovImm = "mov imm"
+	// MovReg is mov reg, reg, a register copy.
+	MovReg = "mov reg"
+	// XorZero is xor reg, reg with the same register twice, which zeroes it.
+	XorZero = "xor zero"
+)
+
+// ErrNoLevel is returned by CheckLevel when the program is well formed but
+// does not satisfy any level.
+var ErrNoLevel = errors.New("no level fits")
+
+// Step is one instruction of the program together with the value it left
+// in its destination register.
+type Step struct {
+	Offset int
+	Intel  string
+	// Form is MovImm, MovReg, XorZero or one of the levels instruction
+	// forms.
+	Form string
+	// Encoding is the levels immediate encoding of an add/sub imm. It does
+	// not affect the level, which only looks at values.
+	Encoding string
+	Dst      string
+	Src      string
+	Imm      int64
+	// Result is the destination after the step, read at the operand size:
+	// a 32-bit op zero-extends into the register, but its value is the
+	// sign-extended int32.
+	Result int64
+
+	dst  emulator.Register
+	size int
+}
+
+// IsOp reports whether the step is an add/sub.
+func (s Step) IsOp() bool {
+	switch s.Form {
+	case levels.AddImm, levels.SubImm, levels.AddReg, levels.SubReg:
+		return true
+	}
+	return false
+}
+
+// Overwrites reports whether the step sets its destination without reading
+// it. sub reg, reg with the same register counts, since it always gives 0.
+func (s Step) Overwrites() bool {
+	switch s.Form {
+	case MovImm, MovReg, XorZero:
+		return true
+	}
+	return s.Form == levels.SubReg && s.Src == s.Dst
+}
+
+// Reads returns the source register whose value the step depends on, or "".
+func (s Step) Reads() string {
+	if s.Form == levels.SubReg && s.Src == s.Dst {
+		return ""
+	}
+	return s.Src
+}
+
+// Rejection says why a level did not accept the program.
+type Rejection struct {
+	Level  int
+	Reason string
+}
+
+// Report describes a program and how it was classified.
+type Report struct {
+	// Level is the first level the program satisfies, or 0.
+	Level int
+	Steps []Step
+	// Ops counts the add/sub instructions.
+	Ops int
+	// Registers lists the registers read or written, in order of first use.
+	Registers []string
+	// Min and Max bound every initial value, immediate and result seen
+	// while running the program.
+	Min, Max int64
+	// Result is RAX at the end, read at the size of the last step that
+	// wrote it.
+	Result int64
+	// Rejected holds the levels tried before Level, or all of them.
+	Rejected []Rejection
+}
+
+// CheckLevel returns the first built-in level the program satisfies. It
+// fails with ErrNoLevel when none does.
 func CheckLevel(codeHex string) (int, error) {
-	code, err := hex.DecodeString(codeHex)
+	report, err := Check(codeHex, levels.Builtin())
 	if err != nil {
 		return 0, err
 	}
+	if report.Level == 0 {
+		var reasons []string
+		for _, r := range report.Rejected {
+			reasons = append(reasons, fmt.Sprintf("level %d: %s", r.Level, r.Reason))
+		}
+		return 0, fmt.Errorf("%w (%s)", ErrNoLevel, strings.Join(reasons, "; "))
+	}
+	return report.Level, nil
+}
 
-	maxImmSize := 0
-	calcCount := 0
-
-	for i := 0; i < len(code); {
-		op := code[i]
+// Check analyzes the program and classifies it against ls. Malformed or
+// unsupported programs are errors; a program that fits no level gets a
+// report with Level 0.
+func Check(codeHex string, ls []levels.Level) (*Report, error) {
+	code, err := emulator.ParseHexString(codeHex)
+	if err != nil {
+		return nil, err
+	}
+	report, err := Analyze(code)
+	if err != nil {
+		return nil, err
+	}
+	report.Classify(ls)
+	return report, nil
+}
 
-		if op >= 0xB8 && op <= 0xBF {
-			if i+5 > len(code) {
-				return 0, nil
-			}
-			maxImmSize = max(maxImmSize, immClass(code[i+1:i+5]))
-			i += 5
+// Classify sets Level to the first level in ls the program satisfies and
+// Rejected to the reasons the levels before it did not.
+func (r *Report) Classify(ls []levels.Level) {
+	r.Level = 0
+	r.Rejected = nil
+	for _, spec := range ls {
+		if reason := r.reject(spec); reason != "" {
+			r.Rejected = append(r.Rejected, Rejection{Level: spec.Level, Reason: reason})
 			continue
 		}
+		r.Level = spec.Level
+		return
+	}
+}
 
-		if op == 0x48 {
-			if i+1 >= len(code) {
-				break
-			}
-			next := code[i+1]
-
-			if next == 0x81 {
-				if i+7 > len(code) {
-					break
-				}
-				maxImmSize = max(maxImmSize, immClass(code[i+3:i+7]))
-				calcCount++
-				i += 7
-				continue
-			}
-
-			if next == 0xB8 {
-				if i+10 > len(code) {
-					break
-				}
-				maxImmSize = 4
-				i += 10
-				continue
-			}
-		}
-
-		if op == 0x05 || op == 0x2D {
-			if i+5 > len(code) {
-				break
-			}
-			maxImmSize = max(maxImmSize, immClass(code[i+1:i+5]))
-			calcCount++
-			i += 5
-			continue
+// Analyze decodes code, checks that it only uses the instructions puzzles
+// are built from and runs it to record the values it produces. The Level
+// of the result is left at 0.
+func Analyze(code []byte) (*Report, error) {
+	report := &Report{}
+	seen := map[string]bool{}
+	use := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			report.Registers = append(report.Registers, name)
 		}
+	}
 
-		if op == 0x01 || op == 0x29 {
-			calcCount++
-			i += 2
-			continue
+	d := emulator.NewDecoder(code)
+	for d.HasMore() {
+		offset := d.Pos()
+		inst, err := d.DecodeNext()
+		if err != nil {
+			return nil, fmt.Errorf("instruction at %04x: %w", offset, err)
 		}
+		step, err := classify(inst)
+		if err != nil {
+			return nil, fmt.Errorf("instruction at %04x (%s): %w", offset, emulator.FormatIntel(inst), err)
+		}
+		if step.IsOp() {
+			report.Ops++
+		}
+		use(step.Dst)
+		use(step.Src)
+		report.Steps = append(report.Steps, step)
+	}
 
-		i++
+	first := true
+	see := func(v int64) {
+		if first || v < report.Min {
+			report.Min = v
+		}
+		if first || v > report.Max {
+			report.Max = v
+		}
+		first = false
 	}
 
-	switch {
-	case maxImmSize == 1 && calcCount >= 1:
-		return 1, nil
-	case maxImmSize == 2:
-		return 2, nil
-	case maxImmSize == 4 && calcCount == 1:
-		return 3, nil
-	case maxImmSize == 4 && calcCount >= 2:
-		return 4, nil
-	}
-	return 0, nil
+	cpu := emulator.NewCPU(emulator.DefaultConfig())
+	m := emulator.NewMachine(cpu, code)
+	for i := range report.Steps {
+		step := &report.Steps[i]
+		if _, err := m.Step(); err != nil {
+			return nil, fmt.Errorf("run: %w", err)
+		}
+		step.Result = cpu.GetRegister(step.dst)
+		if step.size == 4 {
+			step.Result = int64(int32(step.Result))
+		}
+		see(step.Result)
+		if step.IsOp() && step.Src == "" {
+			see(step.Imm)
+		}
+	}
+	report.Result = cpu.GetRegister(emulator.RAX)
+	for i := len(report.Steps) - 1; i >= 0; i-- {
+		if step := report.Steps[i]; step.dst == emulator.RAX {
+			report.Result = step.Result
+			break
+		}
+	}
+	return report, nil
 }
 
-func immClass(b []byte) int {
-	v := int(int32(int32(b[0]) | int32(b[1])<<8 | int32(b[2])<<16 | int32(b[3])<<24))
-	if v >= -128 && v <= 127 {
-		return 1
+// classify maps a decoded instruction to its puzzle form. Only register
+// operands of 32 or 64 bits are accepted, and xor only to zero a register.
+func classify(inst *emulator.Instruction) (Step, error) {
+	if inst.TwoByte || inst.OpSize < 4 {
+		return Step{}, fmt.Errorf("unsupported instruction")
+	}
+	if inst.IsMemory() {
+		return Step{}, fmt.Errorf("memory operands are not allowed")
 	}
-	if v >= -32768 && v <= 32767 {
-		return 2
+
+	rm := emulator.GetRegFromModRM(inst.ModRM, inst.Rex, true)
+	reg := emulator.GetRegFromModRM(inst.ModRM, inst.Rex, false)
+	digit := (inst.ModRM >> 3) & 7
+	step := Step{Offset: inst.Offset, Intel: emulator.FormatIntel(inst), Imm: inst.Immediate(), size: inst.OpSize}
+
+	op := inst.Opcode
+	switch {
+	case op >= 0xB8 && op <= 0xBF:
+		step.Form = MovImm
+		step.dst = emulator.Register(op-0xB8) | emulator.Register(inst.Rex&0x01)<<3
+	case op == 0xC7 && digit == 0:
+		step.Form = MovImm
+		step.dst = rm
+	case op == 0x89:
+		step.Form = MovReg
+		step.dst = rm
+		step.Src = emulator.RegisterName(reg, 8, true)
+	case op == 0x8B:
+		step.Form = MovReg
+		step.dst = reg
+		step.Src = emulator.RegisterName(rm, 8, true)
+	case op == 0x31 || op == 0x33:
+		if rm != reg {
+			return Step{}, fmt.Errorf("xor is only allowed to zero a register")
+		}
+		step.Form = XorZero
+		step.dst = rm
+	case (op == 0x81 || op == 0x83) && (digit == 0 || digit == 5):
+		step.Form = levels.AddImm
+		if digit == 5 {
+			step.Form = levels.SubImm
+		}
+		step.Encoding = levels.Imm32
+		if op == 0x83 {
+			step.Encoding = levels.Imm8
+		}
+		step.dst = rm
+	case op == 0x05 || op == 0x2D:
+		step.Form = levels.AddImm
+		if op == 0x2D {
+			step.Form = levels.SubImm
+		}
+		step.Encoding = levels.Acc
+		step.dst = emulator.RAX
+	case op == 0x01 || op == 0x29:
+		step.Form = levels.AddReg
+		if op == 0x29 {
+			step.Form = levels.SubReg
+		}
+		step.dst = rm
+		step.Src = emulator.RegisterName(reg, 8, true)
+	case op == 0x03 || op == 0x2B:
+		step.Form = levels.AddReg
+		if op == 0x2B {
+			step.Form = levels.SubReg
+		}
+		step.dst = reg
+		step.Src = emulator.RegisterName(rm, 8, true)
+	default:
+		return Step{}, fmt.Errorf("unsupported instruction")
 	}
-	return 4
+	step.Dst = emulator.RegisterName(step.dst, 8, true)
+	return step, nil
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
+// reject returns why spec does not accept the program, or "" if it does.
+// Like the immediate encodings, the registers and instruction forms of a
+// level only shape what the generator writes; a level is decided by the
+// number of add/sub and the size of the values.
+func (r *Report) reject(spec levels.Level) string {
+	if !spec.Ops.Contains(r.Ops) {
+		if spec.Ops.Max == 0 {
+			return fmt.Sprintf("%d add/sub, needs at least %d", r.Ops, spec.Ops.Min)
+		}
+		return fmt.Sprintf("%d add/sub, needs %d to %d", r.Ops, spec.Ops.Min, spec.Ops.Max)
+	}
+	if !spec.InRange(r.Min) {
+		return fmt.Sprintf("value %d is outside int%d", r.Min, spec.ValueBits)
+	}
+	if !spec.InRange(r.Max) {
+		return fmt.Sprintf("value %d is outside int%d", r.Max, spec.ValueBits)
 	}
-	return b
+	return ""
 }
@@ -0,0 +1,138 @@
+package checker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The programs of test/main.js, which the WASM build is checked against.
+func TestCheckLevelWasmTestPrograms(t *testing.T) {
+	tests := []struct {
+		name  string
+		hex   string
+		level int
+	}{
+		// mov rcx, 10; mov rbx, 3; add rcx, rbx; mov rax, rcx
+		{"register copy", "48C7C10A00000048C7C3030000004801D94889C8", 1},
+		// xor rax, rax; add rax, 50000000; sub rax, 29758164
+		{"xor zeroing", "4831c0480580f0fa02482dd416c601", 5},
+		// xor rax, rax; mov rbx, 5; mov rcx, 3; add rax, rbx; add rax, rcx
+		{"register adds", "4831c048c7c30500000048c7c1030000004801d84801c8", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, err := CheckLevel(tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.level, level)
+		})
+	}
+}
+
+func TestCheckLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		hex   string
+		level int
+	}{
+		// mov rax, 5; add rax, 3 (imm8)
+		{"imm8", "48c7c0050000004883c003", 1},
+		// mov rax, 5; add rax, 3 (imm32)
+		{"imm32 encoding of a small value", "48c7c0050000004881c003000000", 1},
+		// mov rax, 5; add rax, 3 (acc)
+		{"acc encoding of a small value", "48c7c005000000480503000000", 1},
+		// mov rax, 100; add rax, 100
+		{"result outside int8", "48c7c0640000004883c064", 2},
+		// mov rax, 0x10000; sub rax, 1
+		{"value outside int16", "48c7c0000001004883e801", 3},
+		// mov rax, 1000; add rax, 1000; sub rax, 500
+		{"two ops in int16", "48c7c0e80300004805e8030000482df4010000", 4},
+		// mov eax, 5; add eax, 3
+		{"32-bit operands", "c7c00500000083c003", 1},
+		// xor eax, eax; add eax, 7
+		{"32-bit xor zeroing", "31c083c007", 1},
+		// mov rbx, 9; mov rax, rbx; sub rax, rax; add rax, 2
+		{"mov reg, reg with 8B", "48c7c309000000488bc34829c04883c002", 4},
+		// mov rax, 1; add rax, 1 four times
+		{"no upper bound on ops", "48c7c0010000004883c0014883c0014883c0014883c001", 4},
+		// mov rax, 100000; add rax, 1; add rax, 1
+		{"two ops in int32", "48c7c0a08601004883c0014883c001", 5},
+		// mov eax, -5; add eax, 3
+		{"negative 32-bit mov", "c7c0fbffffff83c003", 1},
+		// mov eax, -5 (B8+r); add eax, 3
+		{"negative 32-bit short mov", "b8fbffffff83c003", 1},
+		// mov eax, -2000000000; add eax, 500000000 (the Level 3 example of docs/level.md)
+		{"negative int32", "b8006cca88050065cd1d", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, err := CheckLevel(tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.level, level)
+		})
+	}
+}
+
+func TestCheckLevelErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		hex    string
+		noFit  bool
+		errMsg string
+	}{
+		// mov rax, 0x7fffffff; add rax, 1
+		{"result outside int32", "48c7c0ffffff7f480501000000", true, "outside int32"},
+		// xor eax, eax
+		{"no add/sub", "31c0", true, "0 add/sub"},
+		// xor rax, rbx
+		{"xor of two registers", "4831d8", false, "xor is only allowed to zero a register"},
+		// mov rax, [rip]
+		{"memory operand", "488b0500000000", false, "memory operands are not allowed"},
+		// add al, 1
+		{"8-bit operand", "0401", false, "unsupported instruction"},
+		// imul rax, rbx
+		{"other instruction", "480fafc3", false, "unsupported instruction"},
+		{"bad hex", "48c", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CheckLevel(tt.hex)
+			require.Error(t, err)
+			require.Equal(t, tt.noFit, errors.Is(err, ErrNoLevel))
+			require.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestAnalyzeTracksCopiesAndZeroing(t *testing.T) {
+	report, err := Check("4831c048c7c30500000048c7c1030000004801d84801c8", nil)
+	require.NoError(t, err)
+
+	forms := make([]string, len(report.Steps))
+	results := make([]int64, len(report.Steps))
+	for i, step := range report.Steps {
+		forms[i] = step.Form
+		results[i] = step.Result
+	}
+	require.Equal(t, []string{XorZero, MovImm, MovImm, "add reg", "add reg"}, forms)
+	require.Equal(t, []int64{0, 5, 3, 5, 8}, results)
+	require.Equal(t, 2, report.Ops)
+	require.Equal(t, int64(8), report.Result)
+	require.Equal(t, []string{"rax", "rbx", "rcx"}, report.Registers)
+
+	d := report.Difficulty()
+	require.Equal(t, 3, d.LiveRegisters)
+	require.Equal(t, 2, d.Depth)
+}
+
+func TestAnalyzeSignExtends32BitResults(t *testing.T) {
+	// mov eax, -5; add eax, 3
+	report, err := Check("b8fbffffff83c003", nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(-5), report.Steps[0].Result)
+	require.Equal(t, int64(-2), report.Steps[1].Result)
+	require.Equal(t, int64(-5), report.Min)
+	require.Equal(t, int64(3), report.Max)
+	require.Equal(t, int64(-2), report.Result)
+}
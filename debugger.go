@@ -0,0 +1,321 @@
+//go:build !wasm
+// +build !wasm
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"backend/emulator"
+)
+
+const debugHelp = `Commands:
+  break, b <offset>        stop before the instruction at offset
+  delete, d <offset>       remove a breakpoint
+  watch, w <reg>           stop when a 64-bit register changes
+  watch, w *<addr>[:size]  stop when size bytes (default 8) of memory change
+  unwatch <reg|*addr>      remove a watchpoint
+  info break|watch         list breakpoints or watchpoints
+  step, s [n]              execute n instructions (default 1)
+  back, bs [n]             undo the last n instructions (default 1)
+  continue, c              run to the next breakpoint, watchpoint or the end
+  reset                    go back to the start of the program
+  regs, r                  print registers and flags
+  x/<n><fmt><unit> <addr>  examine memory: fmt x, d or u; unit b, h, w or g
+  list, l                  disassemble the program
+  help, h                  show this help
+  quit, q                  leave the debugger`
+
+var examineRe = regexp.MustCompile(`^x(?:/(\d*)([xdu]?)([bhwg]?))?$`)
+
+var examineUnits = map[string]int{"b": 1, "h": 2, "w": 4, "g": 8}
+
+func parseRegister(s string) (emulator.Register, bool) {
+	s = strings.ToLower(s)
+	for i := 0; i < emulator.NumRegisters; i++ {
+		reg := emulator.Register(i)
+		if emulator.RegisterName(reg, 8, true) == s {
+			return reg, true
+		}
+	}
+	return 0, false
+}
+
+// parseAddress accepts a number or register, optionally followed by
+// +number or -number.
+func parseAddress(cpu *emulator.CPU, s string) (uint64, error) {
+	base, offset := s, "0"
+	if i := strings.IndexAny(s, "+-"); i > 0 {
+		base, offset = s[:i], s[i:]
+	}
+	off, err := strconv.ParseInt(offset, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+	if reg, ok := parseRegister(base); ok {
+		return uint64(cpu.GetRegister(reg)) + uint64(off), nil
+	}
+	addr, err := strconv.ParseUint(base, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+	return addr + uint64(off), nil
+}
+
+func parseWatchpoint(cpu *emulator.CPU, arg string) (emulator.Watchpoint, error) {
+	if !strings.HasPrefix(arg, "*") {
+		reg, ok := parseRegister(arg)
+		if !ok {
+			return emulator.Watchpoint{}, fmt.Errorf("unknown register %q (use a 64-bit name such as rax)", arg)
+		}
+		return emulator.Watchpoint{Register: reg}, nil
+	}
+	spec, size := arg[1:], 8
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		n, err := strconv.Atoi(spec[i+1:])
+		if err != nil || n < 1 || n > 8 {
+			return emulator.Watchpoint{}, fmt.Errorf("watch size must be 1 to 8 bytes")
+		}
+		spec, size = spec[:i], n
+	}
+	addr, err := parseAddress(cpu, spec)
+	if err != nil {
+		return emulator.Watchpoint{}, err
+	}
+	return emulator.Watchpoint{Memory: true, Addr: addr, Size: size}, nil
+}
+
+func printCurrent(m *emulator.Machine) {
+	if m.Done() {
+		return
+	}
+	lines, _ := emulator.Disassemble(m.Code())
+	for _, line := range lines {
+		if line.Offset == m.CPU().GetPC() {
+			fmt.Printf("=> %04x: %-24x %s\n", line.Offset, line.Bytes, line.Intel)
+			return
+		}
+	}
+	fmt.Printf("=> %04x: (not at an instruction boundary)\n", m.CPU().GetPC())
+}
+
+func printStop(m *emulator.Machine, stop emulator.Stop) {
+	switch stop.Reason {
+	case emulator.StopBreakpoint:
+		fmt.Printf("Breakpoint at %04x\n", stop.PC)
+	case emulator.StopWatchpoint:
+		fmt.Printf("Watchpoint %s: 0x%x -> 0x%x (%d -> %d)\n",
+			stop.Watch, stop.Old, stop.New, int64(stop.Old), int64(stop.New))
+	case emulator.StopFinished:
+		cpu := m.CPU()
+		fmt.Printf("Program finished after %d steps, RAX=%d (int32 %d)\n",
+			m.Steps(), cpu.GetRegister(emulator.RAX), cpu.GetResult())
+		return
+	}
+	printCurrent(m)
+}
+
+func printListingAt(m *emulator.Machine) {
+	lines, err := emulator.Disassemble(m.Code())
+	breakpoints := map[int]bool{}
+	for _, pc := range m.Breakpoints() {
+		breakpoints[pc] = true
+	}
+	for _, line := range lines {
+		marker := "  "
+		if line.Offset == m.CPU().GetPC() {
+			marker = "=>"
+		}
+		bp := " "
+		if breakpoints[line.Offset] {
+			bp = "*"
+		}
+		fmt.Printf("%s%s %04x: %-24x %s\n", marker, bp, line.Offset, line.Bytes, line.Intel)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+func examine(cpu *emulator.CPU, cmd, arg string) error {
+	match := examineRe.FindStringSubmatch(cmd)
+	if match == nil {
+		return fmt.Errorf("usage: x/<n><fmt><unit> <addr>")
+	}
+	count := 1
+	if match[1] != "" {
+		count, _ = strconv.Atoi(match[1])
+	}
+	format := match[2]
+	if format == "" {
+		format = "x"
+	}
+	unit := examineUnits[match[3]]
+	if unit == 0 {
+		unit = 4
+	}
+	if arg == "" {
+		return fmt.Errorf("x needs an address")
+	}
+	addr, err := parseAddress(cpu, arg)
+	if err != nil {
+		return err
+	}
+
+	perLine := 16 / unit
+	for i := 0; i < count; i++ {
+		if i%perLine == 0 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%016x:", addr+uint64(i*unit))
+		}
+		v := cpu.Memory().Read(addr+uint64(i*unit), unit)
+		switch format {
+		case "d":
+			shift := uint(64 - unit*8)
+			fmt.Printf(" %d", int64(v<<shift)>>shift)
+		case "u":
+			fmt.Printf(" %d", v)
+		default:
+			fmt.Printf(" 0x%0*x", unit*2, v)
+		}
+	}
+	fmt.Println()
+	return nil
+}
+
+// debugREPL drives m with gdb-like commands read from in until quit or end
+// of input.
+func debugREPL(m *emulator.Machine, in io.Reader) {
+	cpu := m.CPU()
+	scanner := bufio.NewScanner(in)
+	fmt.Println("Debugger ready, type help for commands.")
+	printCurrent(m)
+
+	for {
+		fmt.Print("(fml) ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, arg := fields[0], ""
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+
+		var err error
+		switch {
+		case cmd == "help" || cmd == "h":
+			fmt.Println(debugHelp)
+
+		case cmd == "quit" || cmd == "q":
+			return
+
+		case cmd == "break" || cmd == "b" || cmd == "delete" || cmd == "d":
+			var pc int64
+			pc, err = strconv.ParseInt(arg, 0, 64)
+			if err != nil {
+				err = fmt.Errorf("usage: %s <offset>", cmd)
+				break
+			}
+			if cmd == "break" || cmd == "b" {
+				m.AddBreakpoint(int(pc))
+				fmt.Printf("Breakpoint at %04x\n", pc)
+			} else if !m.RemoveBreakpoint(int(pc)) {
+				err = fmt.Errorf("no breakpoint at %04x", pc)
+			}
+
+		case cmd == "watch" || cmd == "w" || cmd == "unwatch":
+			var w emulator.Watchpoint
+			w, err = parseWatchpoint(cpu, arg)
+			if err != nil {
+				break
+			}
+			if cmd == "unwatch" {
+				if !m.RemoveWatchpoint(w) {
+					err = fmt.Errorf("no watchpoint on %s", w)
+				}
+				break
+			}
+			m.AddWatchpoint(w)
+			fmt.Printf("Watching %s\n", w)
+
+		case cmd == "info":
+			switch arg {
+			case "break", "b":
+				for _, pc := range m.Breakpoints() {
+					fmt.Printf("  %04x\n", pc)
+				}
+			case "watch", "w":
+				for _, w := range m.Watchpoints() {
+					fmt.Printf("  %s\n", w)
+				}
+			default:
+				err = fmt.Errorf("usage: info break|watch")
+			}
+
+		case cmd == "step" || cmd == "s":
+			n := 1
+			if arg != "" {
+				if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+					err = fmt.Errorf("usage: step [n]")
+					break
+				}
+			}
+			var stop emulator.Stop
+			if stop, err = m.StepN(n); err == nil {
+				printStop(m, stop)
+			}
+
+		case cmd == "back" || cmd == "bs":
+			n := 1
+			if arg != "" {
+				if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+					err = fmt.Errorf("usage: back [n]")
+					break
+				}
+			}
+			if err = m.Seek(max(m.Steps()-n, 0)); err == nil {
+				fmt.Printf("Back at step %d\n", m.Steps())
+				printCurrent(m)
+			}
+
+		case cmd == "reset":
+			m.Reset()
+			printCurrent(m)
+
+		case cmd == "continue" || cmd == "c":
+			var stop emulator.Stop
+			if stop, err = m.Continue(); err == nil {
+				printStop(m, stop)
+			}
+
+		case cmd == "regs" || cmd == "r":
+			printRegisters(cpu)
+			fmt.Printf("RIP=%04x RFLAGS=0x%x (%s)\n", cpu.GetPC(), cpu.GetFlags(), cpu.FlagsString())
+
+		case cmd == "list" || cmd == "l":
+			printListingAt(m)
+
+		case strings.HasPrefix(cmd, "x"):
+			err = examine(cpu, cmd, arg)
+
+		default:
+			err = fmt.Errorf("unknown command %q, type help for a list", cmd)
+		}
+
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+	}
+}
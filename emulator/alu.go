@@ -0,0 +1,159 @@
+package emulator
+
+import "fmt"
+
+// aluOp numbers the classic two-operand ALU instructions in the order used by
+// both the opcode rows 0x00-0x3F (opcode>>3) and the /digit of group 1.
+type aluOp byte
+
+const (
+	aluAdd aluOp = iota
+	aluOr
+	aluAdc
+	aluSbb
+	aluAnd
+	aluSub
+	aluXor
+	aluCmp
+)
+
+var aluNames = [...]string{"ADD", "OR", "ADC", "SBB", "AND", "SUB", "XOR", "CMP"}
+
+func (op aluOp) String() string {
+	if int(op) >= len(aluNames) {
+		return "unknown"
+	}
+	return aluNames[op]
+}
+
+// alu computes a op b at the given operand size and updates the flags.
+// write is false for operations such as CMP that only produce flags.
+func (cpu *CPU) alu(op aluOp, a, b uint64, size int) (result uint64, write bool, err error) {
+	switch op {
+	case aluAdd, aluSub, aluCmp:
+		width := cpu.arithWidth(size)
+		mask := sizeMask(width)
+		a &= mask
+		b &= mask
+		if op == aluAdd {
+			result = (a + b) & mask
+			cpu.updateFlagsAdd(a, b, result, width)
+		} else {
+			result = (a - b) & mask
+			cpu.updateFlagsSub(a, b, result, width)
+		}
+		if op == aluCmp {
+			return result, false, nil
+		}
+		result, err = cpu.arithResult(op.String(), result, size)
+		return result, true, err
+	case aluAnd, aluOr, aluXor:
+		switch op {
+		case aluAnd:
+			result = a & b
+		case aluOr:
+			result = a | b
+		default:
+			result = a ^ b
+		}
+		result &= sizeMask(size)
+		cpu.updateFlagsLogic(result, size)
+		return result, true, nil
+	default:
+		return 0, false, fmt.Errorf("unsupported ALU operation: %s", op)
+	}
+}
+
+// arithWidth is the width at which signed arithmetic of the given operand
+// size is evaluated under the CPU's overflow policy.
+func (cpu *CPU) arithWidth(size int) int {
+	if cpu.overflow == OverflowWrap32 && size > 4 {
+		return 4
+	}
+	return size
+}
+
+// arithResult applies the overflow policy to a result computed at
+// arithWidth(size), using the OF flag the operation has just set.
+func (cpu *CPU) arithResult(name string, result uint64, size int) (uint64, error) {
+	switch cpu.overflow {
+	case OverflowError:
+		if cpu.GetFlag(FlagOF) {
+			return 0, &EmulatorError{PC: cpu.pc, Kind: KindOverflow, Message: fmt.Sprintf("overflow detected in %s", name)}
+		}
+	case OverflowWrap32:
+		if size > 4 {
+			return uint64(signExtend(result, 4)), nil
+		}
+	}
+	return result, nil
+}
+
+func (cpu *CPU) aluRM(inst *Instruction, op aluOp, src uint64) error {
+	result, write, err := cpu.alu(op, cpu.readRM(inst), src, inst.OpSize)
+	if err != nil {
+		return err
+	}
+	if write {
+		cpu.writeRM(inst, result)
+	}
+	return nil
+}
+
+func (cpu *CPU) aluRegField(inst *Instruction, op aluOp, src uint64) error {
+	result, write, err := cpu.alu(op, cpu.readRegField(inst), src, inst.OpSize)
+	if err != nil {
+		return err
+	}
+	if write {
+		cpu.writeRegField(inst, result)
+	}
+	return nil
+}
+
+func (cpu *CPU) aluAccumulator(inst *Instruction, op aluOp, src uint64) error {
+	result, write, err := cpu.alu(op, cpu.readReg(RAX, inst.OpSize, inst.HasRex), src, inst.OpSize)
+	if err != nil {
+		return err
+	}
+	if write {
+		cpu.writeReg(RAX, inst.OpSize, inst.HasRex, result)
+	}
+	return nil
+}
+
+// neg computes 0 - a, setting flags like SUB (so CF is set unless a is 0).
+func (cpu *CPU) neg(a uint64, size int) (uint64, error) {
+	width := cpu.arithWidth(size)
+	mask := sizeMask(width)
+	a &= mask
+	result := -a & mask
+	cpu.updateFlagsSub(0, a, result, width)
+	return cpu.arithResult("NEG", result, size)
+}
+
+// incDec adds or subtracts 1 with the flags of ADD/SUB, except that CF is
+// preserved.
+func (cpu *CPU) incDec(a uint64, size int, dec bool) (uint64, error) {
+	width := cpu.arithWidth(size)
+	mask := sizeMask(width)
+	a &= mask
+	cf := cpu.GetFlag(FlagCF)
+
+	var result uint64
+	name := "INC"
+	if dec {
+		result = (a - 1) & mask
+		cpu.updateFlagsSub(a, 1, result, width)
+		name = "DEC"
+	} else {
+		result = (a + 1) & mask
+		cpu.updateFlagsAdd(a, 1, result, width)
+	}
+	cpu.SetFlag(FlagCF, cf)
+	return cpu.arithResult(name, result, size)
+}
+
+func (cpu *CPU) test(a, b uint64, size int) {
+	cpu.updateFlagsLogic(a&b&sizeMask(size), size)
+}
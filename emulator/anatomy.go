@@ -0,0 +1,224 @@
+package emulator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AnatomyField is one byte range of an instruction: a prefix, REX, the
+// opcode, ModRM, SIB, displacement, immediate or branch offset.
+type AnatomyField struct {
+	Kind   string // "prefix", "rex", "opcode", "modrm", "sib", "disp", "imm" or "rel"
+	Offset int    // relative to the first byte of the instruction
+	Bytes  []byte
+	Value  int64 // little-endian value, sign-extended; the raw byte for single-byte fields
+	Bits   []BitField
+	Note   string
+}
+
+// BitField is a named group of bits inside REX, ModRM or SIB, most
+// significant first.
+type BitField struct {
+	Name  string
+	Width int
+	Value int
+	Note  string
+}
+
+func (b BitField) Binary() string {
+	return fmt.Sprintf("%0*b", b.Width, b.Value)
+}
+
+// Anatomy splits the instruction bytes of l into their encoding fields in
+// the order they appear.
+func (l DisasmLine) Anatomy() []AnatomyField {
+	inst := l.Inst
+	var fields []AnatomyField
+	pos := 0
+	add := func(kind string, n int, value int64, bits []BitField, note string) {
+		fields = append(fields, AnatomyField{
+			Kind:   kind,
+			Offset: pos,
+			Bytes:  l.Bytes[pos : pos+n],
+			Value:  value,
+			Bits:   bits,
+			Note:   note,
+		})
+		pos += n
+	}
+
+	for pos < len(l.Bytes) && l.Bytes[pos] == 0x66 {
+		add("prefix", 1, 0x66, nil, "operand-size override: 16-bit operands")
+	}
+	if inst.HasRex {
+		add("rex", 1, int64(inst.Rex), rexBits(inst), rexNote(inst))
+	}
+
+	mnemonic, _ := describe(inst)
+	if inst.TwoByte {
+		add("opcode", 2, int64(inst.Opcode), nil, fmt.Sprintf("two-byte opcode 0f %02x: %s", inst.Opcode, mnemonic))
+	} else {
+		add("opcode", 1, int64(inst.Opcode), nil, opcodeNote(inst, mnemonic))
+	}
+
+	if inst.HasModRM {
+		add("modrm", 1, int64(inst.ModRM), modrmBits(inst, mnemonic), "ModRM: mod, reg and rm fields")
+	}
+	if inst.HasSIB {
+		add("sib", 1, int64(inst.SIB), sibBits(inst), "SIB: scale, index and base")
+	}
+	if inst.DispSize > 0 {
+		b := l.Bytes[pos : pos+inst.DispSize]
+		note := fmt.Sprintf("disp%d, little-endian: %s", inst.DispSize*8, littleEndian(b, int64(inst.Disp)))
+		if inst.RIPRel {
+			note += ", relative to the next instruction"
+		}
+		add("disp", inst.DispSize, int64(inst.Disp), nil, note)
+	}
+
+	if inst.HasRel {
+		n := len(l.Bytes) - pos
+		target := inst.Offset + inst.Length + int(inst.Rel)
+		sign, rel := "+", int64(inst.Rel)
+		if rel < 0 {
+			sign, rel = "-", -rel
+		}
+		note := fmt.Sprintf("rel%d, little-endian: %s; target = next instruction 0x%x %s 0x%x = 0x%x",
+			n*8, littleEndian(l.Bytes[pos:], int64(inst.Rel)), inst.Offset+inst.Length, sign, rel, target)
+		add("rel", n, int64(inst.Rel), nil, note)
+	} else if n := len(l.Bytes) - pos; n > 0 {
+		note := fmt.Sprintf("imm%d, little-endian: %s", n*8, littleEndian(l.Bytes[pos:], inst.Immediate()))
+		if n < inst.OpSize && !isByteImmediateOnly(inst) {
+			note += fmt.Sprintf(", sign-extended to %d bits", inst.OpSize*8)
+		}
+		add("imm", n, inst.Immediate(), nil, note)
+	}
+	return fields
+}
+
+// isByteImmediateOnly reports whether the imm8 is a count rather than a
+// value combined with the operand, as for the shift group.
+func isByteImmediateOnly(inst *Instruction) bool {
+	return !inst.TwoByte && (inst.Opcode == 0xC0 || inst.Opcode == 0xC1)
+}
+
+// littleEndian explains how bytes stored low byte first read as a number,
+// e.g. "9c ff ff ff -> 0xffffff9c = -100".
+func littleEndian(b []byte, value int64) string {
+	stored := make([]string, len(b))
+	var reversed strings.Builder
+	for i, v := range b {
+		stored[i] = fmt.Sprintf("%02x", v)
+		fmt.Fprintf(&reversed, "%02x", b[len(b)-1-i])
+	}
+	return fmt.Sprintf("%s -> 0x%s = %d", strings.Join(stored, " "), reversed.String(), value)
+}
+
+func bit(v byte, n uint) int {
+	return int(v>>n) & 1
+}
+
+func rexBits(inst *Instruction) []BitField {
+	rex := inst.Rex
+	w := "operand size from prefix or default"
+	if inst.RexW() {
+		w = "64-bit operand size"
+	}
+	return []BitField{
+		{Name: "fixed", Width: 4, Value: int(rex >> 4), Note: "0100 marks a REX prefix"},
+		{Name: "W", Width: 1, Value: bit(rex, 3), Note: w},
+		{Name: "R", Width: 1, Value: bit(rex, 2), Note: "extends ModRM.reg"},
+		{Name: "X", Width: 1, Value: bit(rex, 1), Note: "extends SIB.index"},
+		{Name: "B", Width: 1, Value: bit(rex, 0), Note: "extends ModRM.rm, SIB.base or the opcode register"},
+	}
+}
+
+func rexNote(inst *Instruction) string {
+	return fmt.Sprintf("REX prefix: W=%d R=%d X=%d B=%d",
+		bit(inst.Rex, 3), bit(inst.Rex, 2), bit(inst.Rex, 1), bit(inst.Rex, 0))
+}
+
+func opcodeNote(inst *Instruction, mnemonic string) string {
+	op := inst.Opcode
+	switch {
+	case op >= 0x50 && op <= 0x5F, op >= 0x91 && op <= 0x97, op == 0x90 && inst.RexB(), op >= 0xB0 && op <= 0xBF:
+		reg := GetRegFromOpcode(op, inst.Rex)
+		return fmt.Sprintf("opcode %02x+r: %s, low 3 bits %03b select %s",
+			op&^0x07, mnemonic, op&0x07, RegisterName(reg, inst.OpSize, inst.HasRex || op < 0xB0))
+	}
+	return fmt.Sprintf("opcode %02x: %s", op, mnemonic)
+}
+
+// regFieldIsDigit reports whether ModRM.reg extends the opcode (/digit)
+// instead of naming a register.
+func regFieldIsDigit(inst *Instruction) bool {
+	if inst.TwoByte {
+		return inst.Opcode >= 0x90 && inst.Opcode <= 0x9F
+	}
+	switch inst.Opcode {
+	case 0x80, 0x81, 0x83, 0x8F, 0xC0, 0xC1, 0xC6, 0xC7,
+		0xD0, 0xD1, 0xD2, 0xD3, 0xF6, 0xF7, 0xFE, 0xFF:
+		return true
+	}
+	return false
+}
+
+func modrmBits(inst *Instruction, mnemonic string) []BitField {
+	modrm := inst.ModRM
+	mod := modrm >> 6
+	reg := (modrm >> 3) & 0x07
+	rm := modrm & 0x07
+
+	var modNote, rmNote string
+	switch {
+	case mod == 0x03:
+		modNote = "11: register operand"
+		rmNote = RegisterName(GetRegFromModRM(modrm, inst.Rex, true), inst.SrcSize(), inst.HasRex)
+	case mod == 0x00 && rm == 0x05:
+		modNote = "00 with rm 101: RIP-relative disp32"
+		rmNote = "rip"
+	case mod == 0x00:
+		modNote = "00: memory, no displacement"
+	case mod == 0x01:
+		modNote = "01: memory + disp8"
+	default:
+		modNote = "10: memory + disp32"
+	}
+	if mod != 0x03 && rmNote == "" {
+		if rm == 0x04 {
+			rmNote = "100: SIB byte follows"
+		} else {
+			rmNote = "base " + regNames64[GetRegFromModRM(modrm, inst.Rex, true)]
+		}
+	}
+
+	var regNote string
+	if regFieldIsDigit(inst) {
+		regNote = fmt.Sprintf("/%d selects %s", reg, mnemonic)
+	} else {
+		regNote = RegisterName(GetRegFromModRM(modrm, inst.Rex, false), inst.OpSize, inst.HasRex)
+	}
+
+	return []BitField{
+		{Name: "mod", Width: 2, Value: int(mod), Note: modNote},
+		{Name: "reg", Width: 3, Value: int(reg), Note: regNote},
+		{Name: "rm", Width: 3, Value: int(rm), Note: rmNote},
+	}
+}
+
+func sibBits(inst *Instruction) []BitField {
+	sib := inst.SIB
+	indexNote := "100: no index"
+	if index, ok := inst.IndexReg(); ok {
+		indexNote = regNames64[index]
+	}
+	baseNote := "no base, disp32 follows"
+	if base, ok := inst.BaseReg(); ok {
+		baseNote = regNames64[base]
+	}
+	return []BitField{
+		{Name: "scale", Width: 2, Value: int(sib >> 6), Note: fmt.Sprintf("x%d", inst.Scale())},
+		{Name: "index", Width: 3, Value: int((sib >> 3) & 0x07), Note: indexNote},
+		{Name: "base", Width: 3, Value: int(sib & 0x07), Note: baseNote},
+	}
+}
@@ -0,0 +1,112 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func anatomyOf(t *testing.T, hex string) []AnatomyField {
+	t.Helper()
+	code, err := ParseHexString(hex)
+	require.NoError(t, err)
+	lines, err := Disassemble(code)
+	require.NoError(t, err)
+	require.Len(t, lines, 1)
+	return lines[0].Anatomy()
+}
+
+func TestAnatomyFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		hex   string
+		kinds []string
+		last  int64
+	}{
+		// add rax, -5
+		{"imm8 group", "4883c0fb", []string{"rex", "opcode", "modrm", "imm"}, -5},
+		// mov r12, [rbx+r13*4+0x10]
+		{"SIB and disp8", "4e8b64ab10", []string{"rex", "opcode", "modrm", "sib", "disp"}, 0x10},
+		// mov rax, [rip+2]
+		{"RIP-relative", "488b0502000000", []string{"rex", "opcode", "modrm", "disp"}, 2},
+		// mov ax, 1
+		{"operand-size prefix", "66b80100", []string{"prefix", "opcode", "imm"}, 1},
+		// sete al
+		{"two-byte opcode", "0f94c0", []string{"opcode", "modrm"}, 0xc0},
+		// jmp to itself
+		{"rel8", "ebfe", []string{"opcode", "rel"}, -2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := anatomyOf(t, tt.hex)
+			var kinds []string
+			total := 0
+			for _, f := range fields {
+				kinds = append(kinds, f.Kind)
+				require.Equal(t, total, f.Offset)
+				total += len(f.Bytes)
+			}
+			require.Equal(t, tt.kinds, kinds)
+			require.Equal(t, len(tt.hex)/2, total)
+			require.Equal(t, tt.last, fields[len(fields)-1].Value)
+		})
+	}
+}
+
+func TestAnatomyBits(t *testing.T) {
+	// mov r12, [rbx+r13*4+0x10]
+	fields := anatomyOf(t, "4e8b64ab10")
+	bits := func(f AnatomyField) []string {
+		var out []string
+		for _, b := range f.Bits {
+			out = append(out, b.Name+"="+b.Binary()+" "+b.Note)
+		}
+		return out
+	}
+	require.Equal(t, []string{
+		"fixed=0100 0100 marks a REX prefix",
+		"W=1 64-bit operand size",
+		"R=1 extends ModRM.reg",
+		"X=1 extends SIB.index",
+		"B=0 extends ModRM.rm, SIB.base or the opcode register",
+	}, bits(fields[0]))
+	require.Equal(t, []string{
+		"mod=01 01: memory + disp8",
+		"reg=100 r12",
+		"rm=100 100: SIB byte follows",
+	}, bits(fields[2]))
+	require.Equal(t, []string{
+		"scale=10 x4",
+		"index=101 r13",
+		"base=011 rbx",
+	}, bits(fields[3]))
+}
+
+func TestAnatomyNotes(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		note string
+	}{
+		// mov rax, -100
+		{"sign-extended imm32", "48c7c09cffffff", "imm32, little-endian: 9c ff ff ff -> 0xffffff9c = -100, sign-extended to 64 bits"},
+		// shl rax, 4
+		{"shift count is not extended", "48c1e004", "imm8, little-endian: 04 -> 0x04 = 4"},
+		// jmp to itself
+		{"branch target", "ebfe", "rel8, little-endian: fe -> 0xfe = -2; target = next instruction 0x2 - 0x2 = 0x0"},
+		// mov rax, [rip+2]
+		{"RIP-relative disp", "488b0502000000", "disp32, little-endian: 02 00 00 00 -> 0x00000002 = 2, relative to the next instruction"},
+		// push r9
+		{"register in the opcode", "4151", "opcode 50+r: push, low 3 bits 001 select r9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := anatomyOf(t, tt.hex)
+			var notes []string
+			for _, f := range fields {
+				notes = append(notes, f.Note)
+			}
+			require.Contains(t, notes, tt.note)
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package emulator
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Watchpoint watches a full 64-bit register or size bytes of memory.
+type Watchpoint struct {
+	Register Register
+	Memory   bool
+	Addr     uint64
+	Size     int
+}
+
+func (w Watchpoint) String() string {
+	if w.Memory {
+		return fmt.Sprintf("[0x%x]:%d", w.Addr, w.Size)
+	}
+	return RegisterName(w.Register, 8, true)
+}
+
+func (w Watchpoint) value(cpu *CPU) uint64 {
+	if w.Memory {
+		return cpu.memory.Read(w.Addr, w.Size)
+	}
+	return uint64(cpu.registers[w.Register])
+}
+
+type watch struct {
+	Watchpoint
+	last uint64
+}
+
+type StopReason int
+
+const (
+	StopFinished StopReason = iota
+	StopBreakpoint
+	StopWatchpoint
+	StopStepped
+)
+
+// Stop says why Continue or StepN returned. Old and New hold the watched
+// value for StopWatchpoint.
+type Stop struct {
+	Reason StopReason
+	PC     int
+	Watch  Watchpoint
+	Old    uint64
+	New    uint64
+}
+
+func (m *Machine) AddBreakpoint(pc int) {
+	if m.breakpoints == nil {
+		m.breakpoints = map[int]bool{}
+	}
+	m.breakpoints[pc] = true
+}
+
+func (m *Machine) RemoveBreakpoint(pc int) bool {
+	if !m.breakpoints[pc] {
+		return false
+	}
+	delete(m.breakpoints, pc)
+	return true
+}
+
+// Breakpoints returns the breakpoint offsets in ascending order.
+func (m *Machine) Breakpoints() []int {
+	pcs := make([]int, 0, len(m.breakpoints))
+	for pc := range m.breakpoints {
+		pcs = append(pcs, pc)
+	}
+	sort.Ints(pcs)
+	return pcs
+}
+
+func (m *Machine) AddWatchpoint(w Watchpoint) {
+	m.watches = append(m.watches, watch{Watchpoint: w, last: w.value(m.cpu)})
+}
+
+func (m *Machine) RemoveWatchpoint(w Watchpoint) bool {
+	for i, cur := range m.watches {
+		if cur.Watchpoint == w {
+			m.watches = append(m.watches[:i], m.watches[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Machine) Watchpoints() []Watchpoint {
+	ws := make([]Watchpoint, len(m.watches))
+	for i, w := range m.watches {
+		ws[i] = w.Watchpoint
+	}
+	return ws
+}
+
+// refreshWatches re-reads the watched values without reporting changes,
+// after the state was moved by something other than execution.
+func (m *Machine) refreshWatches() {
+	for i := range m.watches {
+		m.watches[i].last = m.watches[i].value(m.cpu)
+	}
+}
+
+// checkWatches reports the first watchpoint whose value changed and
+// remembers the new values of all of them.
+func (m *Machine) checkWatches() (Stop, bool) {
+	var stop Stop
+	hit := false
+	for i := range m.watches {
+		w := &m.watches[i]
+		v := w.value(m.cpu)
+		if v != w.last && !hit {
+			stop = Stop{Reason: StopWatchpoint, PC: m.cpu.pc, Watch: w.Watchpoint, Old: w.last, New: v}
+			hit = true
+		}
+		w.last = v
+	}
+	return stop, hit
+}
+
+// StepN executes up to n instructions, stopping early at the end of the
+// program or when a watchpoint changes. Breakpoints are ignored.
+func (m *Machine) StepN(n int) (Stop, error) {
+	for i := 0; i < n; i++ {
+		if m.Done() {
+			return Stop{Reason: StopFinished, PC: m.cpu.pc}, nil
+		}
+		if _, err := m.Step(); err != nil {
+			return Stop{}, err
+		}
+		if stop, hit := m.checkWatches(); hit {
+			return stop, nil
+		}
+	}
+	if m.Done() {
+		return Stop{Reason: StopFinished, PC: m.cpu.pc}, nil
+	}
+	return Stop{Reason: StopStepped, PC: m.cpu.pc}, nil
+}
+
+// Continue runs until the program finishes, a watchpoint changes or the PC
+// reaches a breakpoint. The instruction at the current PC always executes,
+// so continuing from a breakpoint moves past it.
+func (m *Machine) Continue() (Stop, error) {
+	for first := true; !m.Done(); first = false {
+		if !first && m.breakpoints[m.cpu.pc] {
+			return Stop{Reason: StopBreakpoint, PC: m.cpu.pc}, nil
+		}
+		if _, err := m.Step(); err != nil {
+			return Stop{}, err
+		}
+		if stop, hit := m.checkWatches(); hit {
+			return stop, nil
+		}
+	}
+	return Stop{Reason: StopFinished, PC: m.cpu.pc}, nil
+}
@@ -0,0 +1,78 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mov rax, 1; mov rbx, 2; add rax, rbx; mov [0x200], rax
+const debugProgram = "48c7c001000000" + "48c7c302000000" + "4801d8" + "4889042500020000"
+
+func newDebugMachine(t *testing.T) *Machine {
+	t.Helper()
+	code, err := ParseHexString(debugProgram)
+	require.NoError(t, err)
+	return NewMachine(NewCPU(DefaultConfig()), code)
+}
+
+func TestBreakpoints(t *testing.T) {
+	m := newDebugMachine(t)
+	m.AddBreakpoint(17)
+	m.AddBreakpoint(0)
+	m.AddBreakpoint(14)
+	require.Equal(t, []int{0, 14, 17}, m.Breakpoints())
+	require.True(t, m.RemoveBreakpoint(17))
+	require.False(t, m.RemoveBreakpoint(17))
+
+	// the breakpoint at the starting PC does not stop the first Continue
+	stop, err := m.Continue()
+	require.NoError(t, err)
+	require.Equal(t, Stop{Reason: StopBreakpoint, PC: 14}, stop)
+	require.Equal(t, int64(2), m.CPU().GetRegister(RBX))
+
+	stop, err = m.Continue()
+	require.NoError(t, err)
+	require.Equal(t, Stop{Reason: StopFinished, PC: 25}, stop)
+	require.Equal(t, int64(3), m.CPU().GetRegister(RAX))
+}
+
+func TestWatchpoints(t *testing.T) {
+	m := newDebugMachine(t)
+	rax := Watchpoint{Register: RAX}
+	mem := Watchpoint{Memory: true, Addr: 0x200, Size: 8}
+	m.AddWatchpoint(rax)
+	m.AddWatchpoint(mem)
+	require.Equal(t, "rax", rax.String())
+	require.Equal(t, "[0x200]:8", mem.String())
+
+	want := []Stop{
+		{Reason: StopWatchpoint, PC: 7, Watch: rax, Old: 0, New: 1},
+		{Reason: StopWatchpoint, PC: 17, Watch: rax, Old: 1, New: 3},
+		{Reason: StopWatchpoint, PC: 25, Watch: mem, Old: 0, New: 3},
+		{Reason: StopFinished, PC: 25},
+	}
+	for _, w := range want {
+		stop, err := m.Continue()
+		require.NoError(t, err)
+		require.Equal(t, w, stop)
+	}
+
+	require.True(t, m.RemoveWatchpoint(rax))
+	require.False(t, m.RemoveWatchpoint(rax))
+	require.Equal(t, []Watchpoint{mem}, m.Watchpoints())
+}
+
+func TestStepN(t *testing.T) {
+	m := newDebugMachine(t)
+	stop, err := m.StepN(2)
+	require.NoError(t, err)
+	require.Equal(t, Stop{Reason: StopStepped, PC: 14}, stop)
+
+	// StepN ignores breakpoints and stops at the end of the program
+	m.AddBreakpoint(17)
+	stop, err = m.StepN(10)
+	require.NoError(t, err)
+	require.Equal(t, Stop{Reason: StopFinished, PC: 25}, stop)
+	require.Equal(t, 4, m.Steps())
+}
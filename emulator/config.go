@@ -0,0 +1,64 @@
+package emulator
+
+import "fmt"
+
+// OverflowPolicy selects what happens when a signed arithmetic result does
+// not fit its operand size.
+type OverflowPolicy int
+
+const (
+	// OverflowError stops execution with an EmulatorError (the game rule).
+	OverflowError OverflowPolicy = iota
+	// OverflowWrap wraps at the operand size and sets OF like hardware.
+	OverflowWrap
+	// OverflowWrap32 evaluates 64-bit arithmetic at 32 bits and sign-extends
+	// the wrapped result, matching the game's int32 view of the registers.
+	OverflowWrap32
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowError:
+		return "error"
+	case OverflowWrap:
+		return "wrap"
+	case OverflowWrap32:
+		return "wrap32"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "error", "strict", "":
+		return OverflowError, nil
+	case "wrap":
+		return OverflowWrap, nil
+	case "wrap32":
+		return OverflowWrap32, nil
+	default:
+		return 0, fmt.Errorf("unknown overflow policy %q (want error, wrap or wrap32)", s)
+	}
+}
+
+// The stack occupies [StackTop-StackSize, StackTop) and grows down from
+// StackTop, which is where RSP starts.
+const (
+	StackTop         uint64 = 0x100000
+	DefaultStackSize        = 0x1000
+)
+
+type Config struct {
+	Overflow  OverflowPolicy
+	MaxSteps  int
+	StackSize int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Overflow:  OverflowError,
+		MaxSteps:  DefaultMaxSteps,
+		StackSize: DefaultStackSize,
+	}
+}
@@ -0,0 +1,63 @@
+package emulator
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		hex    string
+		policy OverflowPolicy
+		rax    int64
+		of     bool
+	}{
+		// mov eax, 0x7fffffff; add eax, 1
+		{"32-bit wrap", "b8ffffff7f0501000000", OverflowWrap, 0x80000000, true},
+		// mov ax, 0x7fff; add ax, 1
+		{"16-bit wrap", "66b8ff7f66050100", OverflowWrap, 0x8000, true},
+		// movabs rax, 0x7fffffffffffffff; add rax, 1
+		{"64-bit wrap", "48b8ffffffffffffff7f480501000000", OverflowWrap, math.MinInt64, true},
+		// mov rax, 0x7fffffff; add rax, 1 wraps at 32 bits and sign-extends
+		{"wrap32 on 64-bit", "48c7c0ffffff7f480501000000", OverflowWrap32, -0x80000000, true},
+		// mov rax, 0x7fffffff; add rax, 1 does not overflow 64 bits
+		{"strict 64-bit in range", "48c7c0ffffff7f480501000000", OverflowError, 0x80000000, false},
+		// mov rax, -0x80000000; sub rax, 1
+		{"wrap32 sub", "48c7c000000080482d01000000", OverflowWrap32, 0x7fffffff, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHexPolicy(t, tt.hex, tt.policy)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+			require.Equal(t, tt.of, cpu.GetFlag(FlagOF))
+		})
+	}
+}
+
+func TestStrictOverflow(t *testing.T) {
+	for _, hex := range []string{
+		// mov eax, 0x7fffffff; add eax, 1
+		"b8ffffff7f0501000000",
+		// movabs rax, 0x7fffffffffffffff; add rax, 1
+		"48b8ffffffffffffff7f480501000000",
+		// mov ax, -0x8000; sub ax, 1
+		"66b80080662d0100",
+	} {
+		_, err := runHex(t, hex)
+		require.True(t, IsKind(err, KindOverflow), "%s: got %v", hex, err)
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for text, want := range map[string]OverflowPolicy{"": OverflowError, "strict": OverflowError, "error": OverflowError, "wrap": OverflowWrap, "wrap32": OverflowWrap32} {
+		p, err := ParseOverflowPolicy(text)
+		require.NoError(t, err)
+		require.Equal(t, want, p)
+	}
+	_, err := ParseOverflowPolicy("saturate")
+	require.Error(t, err)
+}
@@ -4,38 +4,72 @@ import "fmt"
 
 type Register int
 
+// Register values follow the hardware encoding, so the 3-bit ModRM field
+// extended by the matching REX bit indexes the register file directly.
 const (
 	RAX Register = iota
-	RBX
 	RCX
 	RDX
+	RBX
+	RSP
+	RBP
+	RSI
+	RDI
+	R8
+	R9
+	R10
+	R11
+	R12
+	R13
+	R14
+	R15
 )
 
+const NumRegisters = 16
+
+var registerNames = [NumRegisters]string{
+	"RAX", "RCX", "RDX", "RBX", "RSP", "RBP", "RSI", "RDI",
+	"R8", "R9", "R10", "R11", "R12", "R13", "R14", "R15",
+}
+
+const DefaultMaxSteps = 10000
+
 type CPU struct {
-	registers [4]int64
+	registers [NumRegisters]int64
+	rflags    uint64
 	pc        int
+	maxSteps  int
+	overflow  OverflowPolicy
+	memory    *Memory
+	accesses  []MemoryAccess
+	stackSize uint64
 }
 
 func (r Register) String() string {
-	switch r {
-	case RAX:
-		return "RAX"
-	case RBX:
-		return "RBX"
-	case RCX:
-		return "RCX"
-	case RDX:
-		return "RDX"
-	default:
+	if r < 0 || r >= NumRegisters {
 		return "unknown"
 	}
+	return registerNames[r]
 }
 
-func NewCPU() *CPU {
-	return &CPU{
-		registers: [4]int64{0, 0, 0, 0},
+func NewCPU(cfg Config) *CPU {
+	if cfg.MaxSteps <= 0 {
+		cfg.MaxSteps = DefaultMaxSteps
+	}
+	if cfg.StackSize <= 0 {
+		cfg.StackSize = DefaultStackSize
+	}
+	cpu := &CPU{
+		registers: [NumRegisters]int64{},
+		rflags:    rflagsReserved,
 		pc:        0,
+		maxSteps:  cfg.MaxSteps,
+		overflow:  cfg.Overflow,
+		memory:    NewMemory(),
+		stackSize: uint64(cfg.StackSize),
 	}
+	cpu.registers[RSP] = int64(StackTop)
+	return cpu
 }
 
 func (cpu *CPU) GetRegister(reg Register) int64 {
@@ -46,16 +80,33 @@ func (cpu *CPU) SetRegister(reg Register, value int64) {
 	cpu.registers[reg] = value
 }
 
-func (cpu *CPU) AddOverflowCheck(a, b int64) (int64, bool) {
-	result := a + b
-	overflow := (a > 0 && b > 0 && result < 0) || (a < 0 && b < 0 && result > 0)
-	return result, overflow
+func (cpu *CPU) Memory() *Memory {
+	return cpu.memory
 }
 
-func (cpu *CPU) SubOverflowCheck(a, b int64) (int64, bool) {
-	result := a - b
-	overflow := (a > 0 && b < 0 && result < 0) || (a < 0 && b > 0 && result > 0)
-	return result, overflow
+// LastMemoryAccesses returns the loads and stores made by the most recent
+// call to Execute, in program order.
+func (cpu *CPU) LastMemoryAccesses() []MemoryAccess {
+	return cpu.accesses
+}
+
+func (cpu *CPU) GetPC() int {
+	return cpu.pc
+}
+
+func (cpu *CPU) SetMaxSteps(n int) {
+	cpu.maxSteps = n
+}
+
+func (cpu *CPU) SetOverflowPolicy(p OverflowPolicy) {
+	cpu.overflow = p
+}
+
+// Run maps code into memory at address 0, so RIP-relative operands can read
+// inline data, and executes it from offset 0 until the PC falls off the end
+// of the buffer, following branches and stopping after maxSteps instructions.
+func (cpu *CPU) Run(code []byte) error {
+	return NewMachine(cpu, code).Run()
 }
 
 func (cpu *CPU) GetResult() int32 {
@@ -66,127 +117,278 @@ func (cpu *CPU) GetResult() int32 {
 	return int32(rax)
 }
 
-func (cpu *CPU) Execute(inst *Instruction) error {
-	switch inst.Opcode {
-	case 0xB8, 0xB9, 0xBA, 0xBB:
-		regCode := inst.Opcode - 0xB8
-		reg, _ := GetRegFromModRM(regCode<<3, false)
-		if inst.HasImm64 {
-			cpu.SetRegister(reg, inst.Imm64)
-		} else {
-			cpu.SetRegister(reg, int64(inst.Imm32))
+func (cpu *CPU) jump(inst *Instruction, taken bool) {
+	cpu.pc += inst.Length
+	if taken {
+		cpu.pc += int(inst.Rel)
+	}
+}
+
+func (cpu *CPU) executeTwoByte(inst *Instruction) error {
+	switch {
+	case inst.Opcode >= 0x80 && inst.Opcode <= 0x8F:
+		cpu.jump(inst, cpu.condition(inst.Opcode&0x0F))
+		return nil
+
+	case inst.Opcode >= 0x40 && inst.Opcode <= 0x4F:
+		// the source is read and a 32-bit destination is zero-extended
+		// whether or not the move happens
+		v := cpu.readRM(inst)
+		if !cpu.condition(inst.Opcode & 0x0F) {
+			v = cpu.readRegField(inst)
 		}
+		cpu.writeRegField(inst, v)
 
-	case 0x89:
-		src, err := GetRegFromModRM(inst.ModRM, false)
-		if err != nil {
-			return err
+	case inst.Opcode >= 0x90 && inst.Opcode <= 0x9F:
+		var v uint64
+		if cpu.condition(inst.Opcode & 0x0F) {
+			v = 1
 		}
-		dst, err := GetRegFromModRM(inst.ModRM, true)
+		cpu.writeRM(inst, v)
+
+	case inst.Opcode == 0xB6, inst.Opcode == 0xB7:
+		cpu.writeRegField(inst, cpu.readRMSize(inst, inst.SrcSize()))
+
+	case inst.Opcode == 0xBE, inst.Opcode == 0xBF:
+		size := inst.SrcSize()
+		cpu.writeRegField(inst, uint64(signExtend(cpu.readRMSize(inst, size), size)))
+
+	case inst.Opcode == 0xAF:
+		result, err := cpu.imul(cpu.readRegField(inst), cpu.readRM(inst), inst.OpSize)
 		if err != nil {
 			return err
 		}
-		cpu.SetRegister(dst, cpu.GetRegister(src))
+		cpu.writeRegField(inst, result)
 
-	case 0x8B:
-		src, err := GetRegFromModRM(inst.ModRM, true)
-		if err != nil {
+	default:
+		return fmt.Errorf("unknown opcode: 0x0F 0x%02X", inst.Opcode)
+	}
+
+	cpu.pc += inst.Length
+	return nil
+}
+
+func (cpu *CPU) Execute(inst *Instruction) error {
+	cpu.accesses = nil
+	if inst.TwoByte {
+		return cpu.executeTwoByte(inst)
+	}
+
+	switch inst.Opcode {
+	case 0x70, 0x71, 0x72, 0x73, 0x74, 0x75, 0x76, 0x77,
+		0x78, 0x79, 0x7A, 0x7B, 0x7C, 0x7D, 0x7E, 0x7F:
+		cpu.jump(inst, cpu.condition(inst.Opcode&0x0F))
+		return nil
+
+	case 0xEB, 0xE9:
+		cpu.jump(inst, true)
+		return nil
+
+	case 0xE2:
+		rcx := cpu.GetRegister(RCX) - 1
+		cpu.SetRegister(RCX, rcx)
+		cpu.jump(inst, rcx != 0)
+		return nil
+
+	case 0xE8:
+		if err := cpu.push(uint64(cpu.pc+inst.Length), 8); err != nil {
 			return err
 		}
-		dst, err := GetRegFromModRM(inst.ModRM, false)
+		cpu.jump(inst, true)
+		return nil
+
+	case 0xC3:
+		ret, err := cpu.pop(8)
 		if err != nil {
 			return err
 		}
-		cpu.SetRegister(dst, cpu.GetRegister(src))
+		cpu.pc = int(ret)
+		return nil
 
-	case 0x01:
-		src, err := GetRegFromModRM(inst.ModRM, false)
-		if err != nil {
+	case 0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57:
+		reg := GetRegFromOpcode(inst.Opcode, inst.Rex)
+		if err := cpu.push(cpu.readReg(reg, inst.OpSize, true), inst.OpSize); err != nil {
 			return err
 		}
-		dst, err := GetRegFromModRM(inst.ModRM, true)
+
+	case 0x58, 0x59, 0x5A, 0x5B, 0x5C, 0x5D, 0x5E, 0x5F:
+		v, err := cpu.pop(inst.OpSize)
 		if err != nil {
 			return err
 		}
-		result, overflow := cpu.AddOverflowCheck(cpu.GetRegister(dst), cpu.GetRegister(src))
-		if overflow {
-			return &EmulatorError{PC: cpu.pc, Message: "overflow detected in ADD"}
-		}
-		cpu.SetRegister(dst, result)
+		cpu.writeReg(GetRegFromOpcode(inst.Opcode, inst.Rex), inst.OpSize, true, v)
 
-	case 0x29:
-		src, err := GetRegFromModRM(inst.ModRM, false)
-		if err != nil {
+	case 0x68, 0x6A:
+		if err := cpu.push(cpu.immediate(inst), inst.OpSize); err != nil {
 			return err
 		}
-		dst, err := GetRegFromModRM(inst.ModRM, true)
+
+	case 0x8F:
+		subOpcode := (inst.ModRM >> 3) & 0x07
+		if subOpcode != 0 {
+			return fmt.Errorf("unsupported 0x8F subopcode: %d", subOpcode)
+		}
+		v, err := cpu.pop(inst.OpSize)
 		if err != nil {
 			return err
 		}
-		result, overflow := cpu.SubOverflowCheck(cpu.GetRegister(dst), cpu.GetRegister(src))
-		if overflow {
-			return &EmulatorError{PC: cpu.pc, Message: "overflow detected in SUB"}
-		}
-		cpu.SetRegister(dst, result)
+		cpu.writeRM(inst, v)
 
-	case 0x81:
+	case 0xFE, 0xFF:
 		subOpcode := (inst.ModRM >> 3) & 0x07
-		dst, err := GetRegFromModRM(inst.ModRM, true)
-		if err != nil {
-			return err
+		if inst.Opcode == 0xFE && subOpcode > 1 {
+			return fmt.Errorf("unsupported 0xFE subopcode: %d", subOpcode)
 		}
 		switch subOpcode {
-		case 0:
-			result, overflow := cpu.AddOverflowCheck(cpu.GetRegister(dst), int64(inst.Imm32))
-			if overflow {
-				return &EmulatorError{PC: cpu.pc, Message: "overflow detected in ADD"}
+		case 0, 1:
+			result, err := cpu.incDec(cpu.readRM(inst), inst.OpSize, subOpcode == 1)
+			if err != nil {
+				return err
 			}
-			cpu.SetRegister(dst, result)
-		case 5:
-			result, overflow := cpu.SubOverflowCheck(cpu.GetRegister(dst), int64(inst.Imm32))
-			if overflow {
-				return &EmulatorError{PC: cpu.pc, Message: "overflow detected in SUB"}
+			cpu.writeRM(inst, result)
+		case 2:
+			target := cpu.readRM(inst)
+			if err := cpu.push(uint64(cpu.pc+inst.Length), 8); err != nil {
+				return err
+			}
+			cpu.pc = int(target)
+			return nil
+		case 4:
+			cpu.pc = int(cpu.readRM(inst))
+			return nil
+		case 6:
+			if err := cpu.push(cpu.readRM(inst), inst.OpSize); err != nil {
+				return err
 			}
-			cpu.SetRegister(dst, result)
 		default:
-			return fmt.Errorf("unsupported 0x81 subopcode: %d", subOpcode)
+			return fmt.Errorf("unsupported 0xFF subopcode: %d", subOpcode)
+		}
+
+	case 0x63:
+		size := inst.SrcSize()
+		cpu.writeRegField(inst, uint64(signExtend(cpu.readRMSize(inst, size), size)))
+
+	case 0x8D:
+		if !inst.IsMemory() {
+			return &EmulatorError{PC: cpu.pc, Message: "LEA requires a memory operand"}
 		}
+		cpu.writeRegField(inst, cpu.effectiveAddress(inst))
+
+	case 0x86, 0x87:
+		a, b := cpu.readRM(inst), cpu.readRegField(inst)
+		cpu.writeRM(inst, b)
+		cpu.writeRegField(inst, a)
 
-	case 0xC7:
+	case 0x90, 0x91, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97:
+		// 0x90 without REX.B is NOP rather than xchg eax, eax
+		reg := GetRegFromOpcode(inst.Opcode, inst.Rex)
+		if reg != RAX {
+			a, b := cpu.readReg(RAX, inst.OpSize, true), cpu.readReg(reg, inst.OpSize, true)
+			cpu.writeReg(RAX, inst.OpSize, true, b)
+			cpu.writeReg(reg, inst.OpSize, true, a)
+		}
+
+	case 0xB0, 0xB1, 0xB2, 0xB3, 0xB4, 0xB5, 0xB6, 0xB7,
+		0xB8, 0xB9, 0xBA, 0xBB, 0xBC, 0xBD, 0xBE, 0xBF:
+		reg := GetRegFromOpcode(inst.Opcode, inst.Rex)
+		cpu.writeReg(reg, inst.OpSize, inst.HasRex, cpu.immediate(inst))
+
+	case 0x88, 0x89:
+		cpu.writeRM(inst, cpu.readRegField(inst))
+
+	case 0x8A, 0x8B:
+		cpu.writeRegField(inst, cpu.readRM(inst))
+
+	case 0xC6, 0xC7:
 		subOpcode := (inst.ModRM >> 3) & 0x07
 		if subOpcode != 0 {
-			return fmt.Errorf("unsupported 0xC7 subopcode: %d", subOpcode)
+			return fmt.Errorf("unsupported 0x%02X subopcode: %d", inst.Opcode, subOpcode)
 		}
-		dst, err := GetRegFromModRM(inst.ModRM, true)
-		if err != nil {
+		cpu.writeRM(inst, cpu.immediate(inst))
+
+	case 0x00, 0x01, 0x08, 0x09, 0x20, 0x21, 0x28, 0x29,
+		0x30, 0x31, 0x38, 0x39:
+		if err := cpu.aluRM(inst, aluOp(inst.Opcode>>3), cpu.readRegField(inst)); err != nil {
 			return err
 		}
-		cpu.SetRegister(dst, int64(inst.Imm32))
 
-	case 0x05:
-		result, overflow := cpu.AddOverflowCheck(cpu.GetRegister(RAX), int64(inst.Imm32))
-		if overflow {
-			return &EmulatorError{PC: cpu.pc, Message: "overflow detected in ADD"}
+	case 0x02, 0x03, 0x0A, 0x0B, 0x22, 0x23, 0x2A, 0x2B,
+		0x32, 0x33, 0x3A, 0x3B:
+		if err := cpu.aluRegField(inst, aluOp(inst.Opcode>>3), cpu.readRM(inst)); err != nil {
+			return err
 		}
-		cpu.SetRegister(RAX, result)
 
-	case 0x2D:
-		result, overflow := cpu.SubOverflowCheck(cpu.GetRegister(RAX), int64(inst.Imm32))
-		if overflow {
-			return &EmulatorError{PC: cpu.pc, Message: "overflow detected in SUB"}
+	case 0x04, 0x05, 0x0C, 0x0D, 0x24, 0x25, 0x2C, 0x2D,
+		0x34, 0x35, 0x3C, 0x3D:
+		if err := cpu.aluAccumulator(inst, aluOp(inst.Opcode>>3), cpu.immediate(inst)); err != nil {
+			return err
 		}
-		cpu.SetRegister(RAX, result)
 
-	case 0x31:
-		src, err := GetRegFromModRM(inst.ModRM, false)
+	case 0x80, 0x81, 0x83:
+		subOpcode := (inst.ModRM >> 3) & 0x07
+		switch aluOp(subOpcode) {
+		case aluAdc, aluSbb:
+			return fmt.Errorf("unsupported 0x%02X subopcode: %d", inst.Opcode, subOpcode)
+		default:
+			if err := cpu.aluRM(inst, aluOp(subOpcode), cpu.immediate(inst)); err != nil {
+				return err
+			}
+		}
+
+	case 0xC0, 0xC1, 0xD0, 0xD1, 0xD2, 0xD3:
+		var count uint64
+		switch inst.Opcode {
+		case 0xC0, 0xC1:
+			count = cpu.immediate(inst)
+		case 0xD0, 0xD1:
+			count = 1
+		default:
+			count = cpu.readReg(RCX, 1, false)
+		}
+		result, err := cpu.shift((inst.ModRM>>3)&0x07, cpu.readRM(inst), count, inst.OpSize)
 		if err != nil {
 			return err
 		}
-		dst, err := GetRegFromModRM(inst.ModRM, true)
+		cpu.writeRM(inst, result)
+
+	case 0x84, 0x85:
+		cpu.test(cpu.readRM(inst), cpu.readRegField(inst), inst.OpSize)
+
+	case 0xA8, 0xA9:
+		cpu.test(cpu.readReg(RAX, inst.OpSize, inst.HasRex), cpu.immediate(inst), inst.OpSize)
+
+	case 0xF6, 0xF7:
+		subOpcode := (inst.ModRM >> 3) & 0x07
+		switch subOpcode {
+		case 0:
+			cpu.test(cpu.readRM(inst), cpu.immediate(inst), inst.OpSize)
+		case 2:
+			cpu.writeRM(inst, ^cpu.readRM(inst))
+		case 3:
+			result, err := cpu.neg(cpu.readRM(inst), inst.OpSize)
+			if err != nil {
+				return err
+			}
+			cpu.writeRM(inst, result)
+		case 4, 5:
+			cpu.mulWide(cpu.readRM(inst), inst.OpSize, subOpcode == 5)
+		case 6, 7:
+			if err := cpu.divWide(cpu.readRM(inst), inst.OpSize, subOpcode == 7); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported 0x%02X subopcode: %d", inst.Opcode, subOpcode)
+		}
+
+	case 0x69, 0x6B:
+		result, err := cpu.imul(cpu.readRM(inst), cpu.immediate(inst), inst.OpSize)
 		if err != nil {
 			return err
 		}
-		cpu.SetRegister(dst, cpu.GetRegister(dst)^cpu.GetRegister(src))
+		cpu.writeRegField(inst, result)
+
+	case 0x99:
+		cpu.signExtendAccumulator(inst.OpSize)
 
 	default:
 		return fmt.Errorf("unknown opcode: 0x%02X", inst.Opcode)
@@ -0,0 +1,257 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// runHex runs a hex program with cpu.Run under the default config.
+func runHex(t *testing.T, hex string) (*CPU, error) {
+	t.Helper()
+	return runHexPolicy(t, hex, OverflowError)
+}
+
+func runHexPolicy(t *testing.T, hex string, policy OverflowPolicy) (*CPU, error) {
+	t.Helper()
+	code, err := ParseHexString(hex)
+	require.NoError(t, err)
+	cfg := DefaultConfig()
+	cfg.Overflow = policy
+	cpu := NewCPU(cfg)
+	return cpu, cpu.Run(code)
+}
+
+func TestBranches(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		rax  int64
+	}{
+		// jmp +6 over add rax, 5; add rax, 7
+		{"jmp", "eb06480505000000480507000000", 7},
+		// mov rax, 1; sub rax, 1; jz +6 over add rax, 5; add rax, 7
+		{"jz taken", "48c7c001000000482d010000007406480505000000480507000000", 7},
+		// mov rax, 1; sub rax, 1; jnz +6 over add rax, 5; add rax, 7
+		{"jnz not taken", "48c7c001000000482d010000007506480505000000480507000000", 12},
+		// mov rax, 0; sub rax, 0; jz rel32 +6 over add rax, 5; add rax, 7
+		{"near jz", "48c7c000000000482d000000000f8406000000480505000000480507000000", 7},
+		// mov rax, 1; sub rax, 2; jl +6 over add rax, 5; add rax, 7
+		{"jl on a negative result", "48c7c001000000482d02000000 7c06 480505000000 480507000000", 6},
+		// mov rcx, 5; mov rax, 0; top: add rax, 2; loop top
+		{"loop", "48c7c10500000048c7c000000000480502000000e2f8", 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		kind ErrorKind
+	}{
+		// jmp to itself
+		{"step limit", "ebfe", KindStepLimit},
+		// jmp +0x10 past the end
+		{"jump out of range", "eb10", KindJumpOutOfRange},
+		// jmp back before the start
+		{"jump before start", "ebf0", KindJumpOutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runHex(t, tt.hex)
+			require.True(t, IsKind(err, tt.kind), "got %v", err)
+		})
+	}
+}
+
+func TestSetMaxSteps(t *testing.T) {
+	code, err := ParseHexString("48c7c10500000048c7c000000000480502000000e2f8")
+	require.NoError(t, err)
+	cpu := NewCPU(DefaultConfig())
+	// two movs and five iterations of add and loop
+	cpu.SetMaxSteps(12)
+	require.NoError(t, cpu.Run(code))
+	cpu.SetMaxSteps(11)
+	require.True(t, IsKind(cpu.Run(code), KindStepLimit))
+}
+
+func TestExtendedRegisters(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		reg  Register
+		want int64
+	}{
+		// mov r8, 7; mov r15, 9; add r8, r15
+		{"REX.B and REX.R", "49c7c00700000049c7c7090000004d01f8", R8, 16},
+		// mov r12, 5; mov rax, r12
+		{"REX.R source", "49c7c4050000004c89e0", RAX, 5},
+		// mov rbx, 6; mov r9, rbx
+		{"REX.B destination", "48c7c3060000004989d9", R9, 6},
+		// movabs r10, 0x1122334455667788
+		{"B8+r with REX.B", "49ba8877665544332211", R10, 0x1122334455667788},
+		// mov rdi, 4; mov rsi, 3; sub rsi, rdi
+		{"rsi and rdi", "48c7c70400000048c7c6030000004829fe", RSI, -1},
+		// mov r11, 3; xor r11, r11
+		{"xor r11", "49c7c3030000004d31db", R11, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, cpu.GetRegister(tt.reg))
+		})
+	}
+}
+
+func TestRegisterString(t *testing.T) {
+	require.Equal(t, "RAX", RAX.String())
+	require.Equal(t, "RDI", RDI.String())
+	require.Equal(t, "R15", R15.String())
+	require.Equal(t, "unknown", Register(NumRegisters).String())
+}
+
+func TestOperandSize(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		rax  int64
+	}{
+		// mov eax, -1
+		{"32-bit mov zero-extends", "b8ffffffff", 0xffffffff},
+		// mov rax, -1
+		{"64-bit mov sign-extends imm32", "48c7c0ffffffff", -1},
+		// mov rax, -1; add eax, 0
+		{"32-bit add zero-extends", "48c7c0ffffffff0500000000", 0xffffffff},
+		// mov rax, -1; add ax, 1
+		{"16-bit add keeps upper bits", "48c7c0ffffffff66050100", -0x10000},
+		// mov rax, -1; mov ax, 5
+		{"16-bit mov keeps upper bits", "48c7c0ffffffff66b80500", -0x10000 + 5},
+		// mov rax, -1; mov al, 0
+		{"8-bit mov keeps upper bits", "48c7c0ffffffffb000", -0x100},
+		// mov rax, 0; mov ah, 0x12
+		{"high byte register", "48c7c000000000b412", 0x1200},
+		// mov rcx, -1; mov eax, ecx
+		{"32-bit register mov", "48c7c1ffffffff89c8", 0xffffffff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+		})
+	}
+}
+
+func TestLeaXchgIncDec(t *testing.T) {
+	tests := []struct {
+		name     string
+		hex      string
+		rax, rbx int64
+	}{
+		// mov rbx, 0x10; mov rcx, 3; lea rax, [rbx+rcx*4+5]
+		{"lea", "48c7c31000000048c7c103000000488d448b05", 0x21, 0x10},
+		// lea rax, [rip+0]
+		{"lea rip", "488d0500000000", 7, 0},
+		// mov rax, 1; mov rbx, 2; xchg rax, rbx
+		{"xchg rax short form", "48c7c00100000048c7c3020000004893", 2, 1},
+		// mov rax, 1; mov rbx, 2; xchg rax, rbx (87 /r)
+		{"xchg r/m", "48c7c00100000048c7c3020000004887d8", 2, 1},
+		// mov r8, 7; xchg rax, r8
+		{"xchg with REX.B is not nop", "49c7c0070000004990", 7, 0},
+		// mov eax, 5; nop
+		{"nop", "b80500000090", 5, 0},
+		// mov rax, 41; inc rax
+		{"inc", "48c7c02900000048ffc0", 42, 0},
+		// mov eax, 0; dec eax
+		{"dec zero-extends", "b800000000ffc8", 0xffffffff, 0},
+		// mov al, 0xff; inc al
+		{"inc byte wraps", "b0fffec0", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHexPolicy(t, tt.hex, OverflowWrap)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+			require.Equal(t, tt.rbx, cpu.GetRegister(RBX))
+		})
+	}
+}
+
+func TestIncKeepsCF(t *testing.T) {
+	// mov eax, -1; add eax, 1; inc eax
+	cpu, err := runHex(t, "b8ffffffff0501000000ffc0")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), cpu.GetRegister(RAX))
+	require.Equal(t, flagNames(uint64(FlagCF)), flagNames(cpu.GetFlags()))
+}
+
+func TestWideningMovesAndConditionals(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		rax  int64
+	}{
+		// mov bl, 0xf0; movzx eax, bl
+		{"movzx byte", "b3f00fb6c3", 0xf0},
+		// mov bx, 0xfff0; movzx rax, bx
+		{"movzx word", "66bbf0ff480fb7c3", 0xfff0},
+		// mov bl, 0xf0; movsx rax, bl
+		{"movsx byte", "b3f0480fbec3", -16},
+		// mov bx, 0xfff0; movsx eax, bx
+		{"movsx word into 32 bits", "66bbf0ff0fbfc3", 0xfffffff0},
+		// mov ebx, -1; movsxd rax, ebx
+		{"movsxd", "bbffffffff4863c3", -1},
+		// xor eax, eax; cmp eax, 0; sete al
+		{"sete", "31c083f8000f94c0", 1},
+		// mov ebx, 1; cmp ebx, 2; setl al
+		{"setl", "bb0100000083fb020f9cc0", 1},
+		// mov eax, 0x1234; cmp eax, eax; setne al
+		{"setne writes only al", "b83412000039c00f95c0", 0x1200},
+		// mov rbx, 7; cmp rbx, rbx; cmove rax, rbx
+		{"cmove taken", "48c7c3070000004839db480f44c3", 7},
+		// mov eax, 5; mov ebx, 7; cmp eax, eax; cmovne rax, rbx
+		{"cmovne not taken", "b805000000bb0700000039c0480f45c3", 5},
+		// mov rax, -1; cmp eax, eax; cmovne eax, ebx
+		{"32-bit cmov zero-extends when not taken", "48c7c0ffffffff39c00f45c3", 0xffffffff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+		})
+	}
+}
+
+func TestByteImmediates(t *testing.T) {
+	tests := []struct {
+		name     string
+		hex      string
+		rax, rbx int64
+	}{
+		// mov al, 0x7f; add al, 1
+		{"add al, imm8", "b07f0401", 0x80, 0},
+		// mov al, 0x0f; and al, 0x3c
+		{"and al, imm8", "b00f243c", 0x0c, 0},
+		// mov bl, 0x10; sub bl, 1
+		{"sub r/m8, imm8", "b31080eb01", 0, 0x0f},
+		// mov eax, 0x1234; xor al, 0xff
+		{"xor al keeps the upper bytes", "b83412000034ff", 0x12cb, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHexPolicy(t, tt.hex, OverflowWrap)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+			require.Equal(t, tt.rbx, cpu.GetRegister(RBX))
+		})
+	}
+}
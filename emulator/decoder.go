@@ -8,18 +8,42 @@ type Decoder struct {
 }
 
 type Instruction struct {
+	Offset   int
+	Prefix66 bool
+	TwoByte  bool
 	Opcode   byte
 	ModRM    byte
 	HasModRM bool
+	SIB      byte
+	HasSIB   bool
+	Disp     int32
+	DispSize int
+	RIPRel   bool
 	Rex      byte
 	HasRex   bool
+	OpSize   int
+	Imm8     int8
+	HasImm8  bool
+	Imm16    int16
+	HasImm16 bool
 	Imm32    int32
 	HasImm32 bool
 	Imm64    int64
 	HasImm64 bool
+	Rel      int32
+	HasRel   bool
 	Length   int
 }
 
+// immediate operand kinds, resolved against the operand size once the
+// prefixes and opcode are known
+const (
+	immNone = iota
+	immByte
+	immZ // iw with a 0x66 prefix, id otherwise
+	immV // iw, id or iq: only MOV r, imm
+)
+
 func NewDecoder(code []byte) *Decoder {
 	return &Decoder{
 		code: code,
@@ -43,6 +67,23 @@ func (d *Decoder) PeekByte() (byte, error) {
 	return d.code[d.pos], nil
 }
 
+func (d *Decoder) ReadImm8() (int8, error) {
+	b, err := d.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	return int8(b), nil
+}
+
+func (d *Decoder) ReadImm16() (int16, error) {
+	if d.pos+2 > len(d.code) {
+		return 0, fmt.Errorf("unexpected end of code")
+	}
+	imm := int16(d.code[d.pos]) | int16(d.code[d.pos+1])<<8
+	d.pos += 2
+	return imm, nil
+}
+
 func (d *Decoder) ReadImm32() (int32, error) {
 	if d.pos+4 > len(d.code) {
 		return 0, fmt.Errorf("unexpected end of code")
@@ -65,13 +106,21 @@ func (d *Decoder) ReadImm64() (int64, error) {
 
 func (d *Decoder) DecodeNext() (*Instruction, error) {
 	startPos := d.pos
-	inst := &Instruction{}
+	inst := &Instruction{Offset: startPos}
 
 	b, err := d.PeekByte()
 	if err != nil {
 		return nil, err
 	}
-	if b >= 0x48 && b <= 0x4F {
+	for b == 0x66 {
+		d.pos++
+		inst.Prefix66 = true
+		b, err = d.PeekByte()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b >= 0x40 && b <= 0x4F {
 		inst.Rex, _ = d.ReadByte()
 		inst.HasRex = true
 	}
@@ -82,30 +131,148 @@ func (d *Decoder) DecodeNext() (*Instruction, error) {
 	}
 
 	needsModRM := false
-	needsImm32 := false
-
-	switch inst.Opcode {
-	case 0x89, 0x8B:
-		needsModRM = true
-	case 0x01, 0x29, 0x31:
-		needsModRM = true
-	case 0x05, 0x2D:
-		needsImm32 = true
-	case 0x81, 0xC7:
-		needsModRM = true
-		needsImm32 = true
-	case 0xB8, 0xB9, 0xBA, 0xBB:
-		if inst.HasRex {
-			inst.Imm64, err = d.ReadImm64()
-			if err != nil {
-				return nil, err
-			}
-			inst.HasImm64 = true
-		} else {
-			needsImm32 = true
+	byteOp := false
+	op64 := false
+	imm := immNone
+	relSize := 0
+
+	if inst.Opcode == 0x0F {
+		inst.TwoByte = true
+		inst.Opcode, err = d.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case inst.Opcode >= 0x80 && inst.Opcode <= 0x8F:
+			relSize = 4
+		case inst.Opcode >= 0x40 && inst.Opcode <= 0x4F:
+			needsModRM = true
+		case inst.Opcode >= 0x90 && inst.Opcode <= 0x9F:
+			needsModRM = true
+			byteOp = true
+		case inst.Opcode == 0xAF:
+			needsModRM = true
+		case inst.Opcode == 0xB6, inst.Opcode == 0xB7, inst.Opcode == 0xBE, inst.Opcode == 0xBF:
+			needsModRM = true
+		default:
+			return nil, &EmulatorError{PC: startPos, Message: fmt.Sprintf("unknown opcode 0x0F 0x%02X", inst.Opcode)}
 		}
+	} else {
+		switch inst.Opcode {
+		case 0x70, 0x71, 0x72, 0x73, 0x74, 0x75, 0x76, 0x77,
+			0x78, 0x79, 0x7A, 0x7B, 0x7C, 0x7D, 0x7E, 0x7F:
+			relSize = 1
+		case 0xEB, 0xE2:
+			relSize = 1
+		case 0xE9:
+			relSize = 4
+		case 0xE8:
+			relSize = 4
+			op64 = true
+		case 0xC3:
+			op64 = true
+		case 0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57,
+			0x58, 0x59, 0x5A, 0x5B, 0x5C, 0x5D, 0x5E, 0x5F:
+			op64 = true
+		case 0x68:
+			op64 = true
+			imm = immZ
+		case 0x6A:
+			op64 = true
+			imm = immByte
+		case 0x8F:
+			needsModRM = true
+			op64 = true
+		case 0xFF:
+			needsModRM = true
+		case 0xFE:
+			needsModRM = true
+			byteOp = true
+		case 0x8D, 0x63:
+			needsModRM = true
+		case 0x86:
+			needsModRM = true
+			byteOp = true
+		case 0x87:
+			needsModRM = true
+		case 0x90, 0x91, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97:
+		case 0x88, 0x8A:
+			needsModRM = true
+			byteOp = true
+		case 0x89, 0x8B:
+			needsModRM = true
+		case 0x00, 0x02, 0x08, 0x0A, 0x20, 0x22, 0x28, 0x2A,
+			0x30, 0x32, 0x38, 0x3A, 0x84:
+			needsModRM = true
+			byteOp = true
+		case 0x01, 0x03, 0x09, 0x0B, 0x21, 0x23, 0x29, 0x2B,
+			0x31, 0x33, 0x39, 0x3B, 0x85:
+			needsModRM = true
+		case 0x05, 0x0D, 0x25, 0x2D, 0x35, 0x3D, 0xA9:
+			imm = immZ
+		case 0x04, 0x0C, 0x24, 0x2C, 0x34, 0x3C, 0xA8:
+			byteOp = true
+			imm = immByte
+		case 0x80:
+			needsModRM = true
+			byteOp = true
+			imm = immByte
+		case 0x81, 0xC7:
+			needsModRM = true
+			imm = immZ
+		case 0x83:
+			needsModRM = true
+			imm = immByte
+		case 0xC0:
+			needsModRM = true
+			byteOp = true
+			imm = immByte
+		case 0xC1:
+			needsModRM = true
+			imm = immByte
+		case 0xD0, 0xD2:
+			needsModRM = true
+			byteOp = true
+		case 0xD1, 0xD3:
+			needsModRM = true
+		case 0xC6:
+			needsModRM = true
+			byteOp = true
+			imm = immByte
+		case 0xF6:
+			needsModRM = true
+			byteOp = true
+		case 0xF7:
+			needsModRM = true
+		case 0x69:
+			needsModRM = true
+			imm = immZ
+		case 0x6B:
+			needsModRM = true
+			imm = immByte
+		case 0x99:
+		case 0xB0, 0xB1, 0xB2, 0xB3, 0xB4, 0xB5, 0xB6, 0xB7:
+			byteOp = true
+			imm = immByte
+		case 0xB8, 0xB9, 0xBA, 0xBB, 0xBC, 0xBD, 0xBE, 0xBF:
+			imm = immV
+		default:
+			return nil, &EmulatorError{PC: startPos, Message: fmt.Sprintf("unknown opcode 0x%02X", inst.Opcode)}
+		}
+	}
+
+	switch {
+	case byteOp:
+		inst.OpSize = 1
+	case inst.RexW():
+		inst.OpSize = 8
+	case op64 && !inst.Prefix66:
+		inst.OpSize = 8
+	case inst.Prefix66:
+		inst.OpSize = 2
 	default:
-		return nil, &EmulatorError{PC: startPos, Message: fmt.Sprintf("unknown opcode 0x%02X", inst.Opcode)}
+		inst.OpSize = 4
 	}
 
 	if needsModRM {
@@ -115,47 +282,245 @@ func (d *Decoder) DecodeNext() (*Instruction, error) {
 		}
 		inst.HasModRM = true
 
-		mod := (inst.ModRM >> 6) & 0x03
-		if mod != 0x03 {
-			return nil, &EmulatorError{PC: startPos, Message: "memory access not supported"}
+		if err := d.readAddressing(inst); err != nil {
+			return nil, err
+		}
+
+		// TEST r/m, imm shares 0xF6/0xF7 with the unary group and is the
+		// only member carrying an immediate
+		if !inst.TwoByte && (inst.ModRM>>3)&0x07 == 0 {
+			switch inst.Opcode {
+			case 0xF6:
+				imm = immByte
+			case 0xF7:
+				imm = immZ
+			}
+		}
+
+		// near CALL, JMP and PUSH through 0xFF default to 64-bit operands
+		if !inst.TwoByte && inst.Opcode == 0xFF && !inst.Prefix66 {
+			switch (inst.ModRM >> 3) & 0x07 {
+			case 2, 4, 6:
+				inst.OpSize = 8
+			}
 		}
 	}
 
-	if needsImm32 {
-		inst.Imm32, err = d.ReadImm32()
+	if err := d.readImmediate(inst, imm); err != nil {
+		return nil, err
+	}
+
+	switch relSize {
+	case 1:
+		rel, err := d.ReadImm8()
+		if err != nil {
+			return nil, err
+		}
+		inst.Rel = int32(rel)
+		inst.HasRel = true
+	case 4:
+		inst.Rel, err = d.ReadImm32()
 		if err != nil {
 			return nil, err
 		}
-		inst.HasImm32 = true
+		inst.HasRel = true
 	}
 
 	inst.Length = d.pos - startPos
 	return inst, nil
 }
 
-func (d *Decoder) HasMore() bool {
-	return d.pos < len(d.code)
+// readAddressing reads the SIB byte and displacement that follow a memory
+// form ModRM byte.
+func (d *Decoder) readAddressing(inst *Instruction) error {
+	mod := inst.ModRM >> 6
+	rm := inst.ModRM & 0x07
+	if mod == 0x03 {
+		return nil
+	}
+
+	var err error
+	if rm == 0x04 {
+		inst.SIB, err = d.ReadByte()
+		if err != nil {
+			return err
+		}
+		inst.HasSIB = true
+	}
+
+	switch {
+	case mod == 0x01:
+		inst.DispSize = 1
+	case mod == 0x02:
+		inst.DispSize = 4
+	case rm == 0x05:
+		inst.RIPRel = true
+		inst.DispSize = 4
+	case inst.HasSIB && inst.SIB&0x07 == 0x05:
+		inst.DispSize = 4
+	}
+
+	switch inst.DispSize {
+	case 1:
+		disp, err := d.ReadImm8()
+		if err != nil {
+			return err
+		}
+		inst.Disp = int32(disp)
+	case 4:
+		inst.Disp, err = d.ReadImm32()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func GetRegFromModRM(modrm byte, isRM bool) (Register, error) {
-	var code byte
-	if isRM {
-		code = modrm & 0x07
-	} else {
-		code = (modrm >> 3) & 0x07
+func (d *Decoder) readImmediate(inst *Instruction, kind int) error {
+	size := 0
+	switch kind {
+	case immByte:
+		size = 1
+	case immZ:
+		size = min(inst.OpSize, 4)
+	case immV:
+		size = inst.OpSize
 	}
 
-	switch code {
-	case 0:
-		return RAX, nil
-	case 3:
-		return RBX, nil
+	var err error
+	switch size {
 	case 1:
-		return RCX, nil
+		inst.Imm8, err = d.ReadImm8()
+		inst.HasImm8 = err == nil
 	case 2:
-		return RDX, nil
+		inst.Imm16, err = d.ReadImm16()
+		inst.HasImm16 = err == nil
+	case 4:
+		inst.Imm32, err = d.ReadImm32()
+		inst.HasImm32 = err == nil
+	case 8:
+		inst.Imm64, err = d.ReadImm64()
+		inst.HasImm64 = err == nil
+	}
+	return err
+}
+
+// Immediate returns the immediate operand sign-extended to 64 bits.
+func (inst *Instruction) Immediate() int64 {
+	switch {
+	case inst.HasImm8:
+		return int64(inst.Imm8)
+	case inst.HasImm16:
+		return int64(inst.Imm16)
+	case inst.HasImm32:
+		return int64(inst.Imm32)
+	case inst.HasImm64:
+		return inst.Imm64
+	default:
+		return 0
+	}
+}
+
+func (d *Decoder) Pos() int {
+	return d.pos
+}
+
+func (d *Decoder) Seek(pos int) error {
+	if pos < 0 || pos > len(d.code) {
+		return fmt.Errorf("seek position %d out of range", pos)
+	}
+	d.pos = pos
+	return nil
+}
+
+func (d *Decoder) HasMore() bool {
+	return d.pos < len(d.code)
+}
+
+func (inst *Instruction) RexW() bool {
+	return inst.Rex&0x08 != 0
+}
+
+func (inst *Instruction) RexR() bool {
+	return inst.Rex&0x04 != 0
+}
+
+func (inst *Instruction) RexX() bool {
+	return inst.Rex&0x02 != 0
+}
+
+func (inst *Instruction) RexB() bool {
+	return inst.Rex&0x01 != 0
+}
+
+func (inst *Instruction) IsMemory() bool {
+	return inst.HasModRM && inst.ModRM>>6 != 0x03
+}
+
+// BaseReg returns the base register of a memory operand. ok is false for
+// RIP-relative and absolute disp32 forms.
+func (inst *Instruction) BaseReg() (reg Register, ok bool) {
+	if !inst.IsMemory() || inst.RIPRel {
+		return 0, false
+	}
+	if !inst.HasSIB {
+		return GetRegFromModRM(inst.ModRM, inst.Rex, true), true
+	}
+	if inst.SIB&0x07 == 0x05 && inst.ModRM>>6 == 0x00 {
+		return 0, false
+	}
+	return Register(inst.SIB&0x07 | (inst.Rex&0x01)<<3), true
+}
+
+// IndexReg returns the scaled index register of a memory operand. An index
+// field of 100 without REX.X means "no index".
+func (inst *Instruction) IndexReg() (reg Register, ok bool) {
+	if !inst.IsMemory() || !inst.HasSIB {
+		return 0, false
+	}
+	index := Register((inst.SIB>>3)&0x07 | (inst.Rex&0x02)<<2)
+	if index == RSP {
+		return 0, false
+	}
+	return index, true
+}
+
+func (inst *Instruction) Scale() int {
+	if !inst.HasSIB {
+		return 1
+	}
+	return 1 << (inst.SIB >> 6)
+}
+
+// SrcSize returns the size of the r/m source operand, which differs from
+// OpSize only for the widening moves (MOVZX, MOVSX, MOVSXD).
+func (inst *Instruction) SrcSize() int {
+	switch {
+	case inst.TwoByte && (inst.Opcode == 0xB6 || inst.Opcode == 0xBE):
+		return 1
+	case inst.TwoByte && (inst.Opcode == 0xB7 || inst.Opcode == 0xBF):
+		return 2
+	case !inst.TwoByte && inst.Opcode == 0x63:
+		return min(inst.OpSize, 4)
 	default:
-		return 0, fmt.Errorf("not allowed register code: %d", code)
+		return inst.OpSize
+	}
+}
 
+// GetRegFromModRM returns the register named by the reg (isRM=false) or
+// rm (isRM=true) field of modrm, extended by REX.R or REX.B respectively.
+func GetRegFromModRM(modrm, rex byte, isRM bool) Register {
+	var code byte
+	if isRM {
+		code = modrm&0x07 | (rex&0x01)<<3
+	} else {
+		code = (modrm>>3)&0x07 | (rex&0x04)<<1
 	}
+	return Register(code)
+}
+
+// GetRegFromOpcode returns the register encoded in the low 3 bits of a
+// "+r" opcode such as 0xB8+r, extended by REX.B.
+func GetRegFromOpcode(opcode, rex byte) Register {
+	return Register(opcode&0x07 | (rex&0x01)<<3)
 }
@@ -0,0 +1,425 @@
+package emulator
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DisasmLine struct {
+	Offset int
+	Bytes  []byte
+	Intel  string
+	ATT    string
+	Inst   *Instruction
+}
+
+// Disassemble decodes code linearly from offset 0. On a decode error the
+// lines decoded so far are returned together with the error.
+func Disassemble(code []byte) ([]DisasmLine, error) {
+	var lines []DisasmLine
+	decoder := NewDecoder(code)
+	for decoder.HasMore() {
+		inst, err := decoder.DecodeNext()
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, DisasmLine{
+			Offset: inst.Offset,
+			Bytes:  code[inst.Offset : inst.Offset+inst.Length],
+			Intel:  FormatIntel(inst),
+			ATT:    FormatATT(inst),
+			Inst:   inst,
+		})
+	}
+	return lines, nil
+}
+
+var regNames64 = [NumRegisters]string{
+	"rax", "rcx", "rdx", "rbx", "rsp", "rbp", "rsi", "rdi",
+	"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15",
+}
+
+var regNames32 = [NumRegisters]string{
+	"eax", "ecx", "edx", "ebx", "esp", "ebp", "esi", "edi",
+	"r8d", "r9d", "r10d", "r11d", "r12d", "r13d", "r14d", "r15d",
+}
+
+var regNames16 = [NumRegisters]string{
+	"ax", "cx", "dx", "bx", "sp", "bp", "si", "di",
+	"r8w", "r9w", "r10w", "r11w", "r12w", "r13w", "r14w", "r15w",
+}
+
+var regNames8 = [NumRegisters]string{
+	"al", "cl", "dl", "bl", "spl", "bpl", "sil", "dil",
+	"r8b", "r9b", "r10b", "r11b", "r12b", "r13b", "r14b", "r15b",
+}
+
+var highByteNames = [4]string{"ah", "ch", "dh", "bh"}
+
+// RegisterName returns the lower-case name of reg accessed at size bytes.
+// rex selects SPL..DIL over AH..BH for 8-bit codes 4-7.
+func RegisterName(reg Register, size int, rex bool) string {
+	if reg < 0 || reg >= NumRegisters {
+		return "unknown"
+	}
+	switch size {
+	case 1:
+		if isHighByteReg(reg, size, rex) {
+			return highByteNames[reg-RSP]
+		}
+		return regNames8[reg]
+	case 2:
+		return regNames16[reg]
+	case 4:
+		return regNames32[reg]
+	default:
+		return regNames64[reg]
+	}
+}
+
+var conditionNames = [16]string{
+	"o", "no", "b", "ae", "e", "ne", "be", "a",
+	"s", "ns", "p", "np", "l", "ge", "le", "g",
+}
+
+var shiftNames = [8]string{"rol", "ror", "rcl", "rcr", "shl", "shr", "sal", "sar"}
+
+var group3Names = [8]string{"test", "test", "not", "neg", "mul", "imul", "div", "idiv"}
+
+type operandKind int
+
+const (
+	opReg operandKind = iota
+	opMem
+	opImm
+	opRel
+)
+
+type operand struct {
+	kind operandKind
+	reg  Register
+	size int
+	rex  bool
+	imm  int64
+	inst *Instruction
+	// unsigned prints an immediate as its bits at the operand size, as
+	// objdump does for mov, logical ops and 8-bit operands
+	unsigned bool
+}
+
+func regOp(reg Register, size int, rex bool) operand {
+	return operand{kind: opReg, reg: reg, size: size, rex: rex}
+}
+
+func immOp(v int64, size int) operand {
+	return operand{kind: opImm, imm: v, size: size}
+}
+
+func (inst *Instruction) rmOp(size int) operand {
+	if inst.IsMemory() {
+		return operand{kind: opMem, size: size, inst: inst}
+	}
+	return regOp(GetRegFromModRM(inst.ModRM, inst.Rex, true), size, inst.HasRex)
+}
+
+func (inst *Instruction) regOp(size int) operand {
+	return regOp(GetRegFromModRM(inst.ModRM, inst.Rex, false), size, inst.HasRex)
+}
+
+func (inst *Instruction) relOp() operand {
+	return operand{kind: opRel, imm: int64(inst.Offset + inst.Length + int(inst.Rel))}
+}
+
+func (inst *Instruction) immOp() operand {
+	return immOp(inst.Immediate(), inst.OpSize)
+}
+
+func (inst *Instruction) uimmOp() operand {
+	op := inst.immOp()
+	op.unsigned = true
+	return op
+}
+
+// aluImmOp is the immediate of an ALU op: unsigned for and, or, xor and
+// 8-bit operands, signed for arithmetic.
+func (inst *Instruction) aluImmOp(op aluOp) operand {
+	if op == aluAnd || op == aluOr || op == aluXor || inst.OpSize == 1 {
+		return inst.uimmOp()
+	}
+	return inst.immOp()
+}
+
+// describe returns the Intel mnemonic and the operands in Intel order.
+func describe(inst *Instruction) (string, []operand) {
+	size := inst.OpSize
+	digit := (inst.ModRM >> 3) & 0x07
+	acc := regOp(RAX, size, inst.HasRex)
+
+	if inst.TwoByte {
+		switch op := inst.Opcode; {
+		case op >= 0x40 && op <= 0x4F:
+			return "cmov" + conditionNames[op&0x0F], []operand{inst.regOp(size), inst.rmOp(size)}
+		case op >= 0x80 && op <= 0x8F:
+			return "j" + conditionNames[op&0x0F], []operand{inst.relOp()}
+		case op >= 0x90 && op <= 0x9F:
+			return "set" + conditionNames[op&0x0F], []operand{inst.rmOp(1)}
+		case op == 0xAF:
+			return "imul", []operand{inst.regOp(size), inst.rmOp(size)}
+		case op == 0xB6, op == 0xB7:
+			return "movzx", []operand{inst.regOp(size), inst.rmOp(inst.SrcSize())}
+		case op == 0xBE, op == 0xBF:
+			return "movsx", []operand{inst.regOp(size), inst.rmOp(inst.SrcSize())}
+		}
+		return fmt.Sprintf("(bad 0f %02x)", inst.Opcode), nil
+	}
+
+	switch op := inst.Opcode; {
+	case op <= 0x3D && op&0x07 <= 0x05:
+		name := strings.ToLower(aluOp(op >> 3).String())
+		switch op & 0x07 {
+		case 0, 1:
+			return name, []operand{inst.rmOp(size), inst.regOp(size)}
+		case 2, 3:
+			return name, []operand{inst.regOp(size), inst.rmOp(size)}
+		default:
+			return name, []operand{acc, inst.aluImmOp(aluOp(op >> 3))}
+		}
+	case op >= 0x50 && op <= 0x57:
+		return "push", []operand{regOp(GetRegFromOpcode(op, inst.Rex), size, true)}
+	case op >= 0x58 && op <= 0x5F:
+		return "pop", []operand{regOp(GetRegFromOpcode(op, inst.Rex), size, true)}
+	case op == 0x63:
+		return "movsxd", []operand{inst.regOp(size), inst.rmOp(inst.SrcSize())}
+	case op == 0x68, op == 0x6A:
+		return "push", []operand{inst.immOp()}
+	case op == 0x69, op == 0x6B:
+		return "imul", []operand{inst.regOp(size), inst.rmOp(size), inst.immOp()}
+	case op >= 0x70 && op <= 0x7F:
+		return "j" + conditionNames[op&0x0F], []operand{inst.relOp()}
+	case op == 0x80, op == 0x81, op == 0x83:
+		return strings.ToLower(aluOp(digit).String()), []operand{inst.rmOp(size), inst.aluImmOp(aluOp(digit))}
+	case op == 0x84, op == 0x85:
+		return "test", []operand{inst.rmOp(size), inst.regOp(size)}
+	case op == 0x86, op == 0x87:
+		return "xchg", []operand{inst.rmOp(size), inst.regOp(size)}
+	case op == 0x88, op == 0x89:
+		return "mov", []operand{inst.rmOp(size), inst.regOp(size)}
+	case op == 0x8A, op == 0x8B:
+		return "mov", []operand{inst.regOp(size), inst.rmOp(size)}
+	case op == 0x8D:
+		return "lea", []operand{inst.regOp(size), inst.rmOp(0)}
+	case op == 0x8F:
+		return "pop", []operand{inst.rmOp(size)}
+	case op == 0x90 && !inst.RexB():
+		return "nop", nil
+	case op >= 0x90 && op <= 0x97:
+		return "xchg", []operand{acc, regOp(GetRegFromOpcode(op, inst.Rex), size, true)}
+	case op == 0x99:
+		switch size {
+		case 2:
+			return "cwd", nil
+		case 4:
+			return "cdq", nil
+		default:
+			return "cqo", nil
+		}
+	case op == 0xA8, op == 0xA9:
+		return "test", []operand{acc, inst.uimmOp()}
+	case op >= 0xB0 && op <= 0xBF && inst.HasImm64:
+		return "movabs", []operand{regOp(GetRegFromOpcode(op, inst.Rex), size, inst.HasRex), inst.uimmOp()}
+	case op >= 0xB0 && op <= 0xBF:
+		return "mov", []operand{regOp(GetRegFromOpcode(op, inst.Rex), size, inst.HasRex), inst.uimmOp()}
+	case op == 0xC0, op == 0xC1:
+		return shiftNames[digit], []operand{inst.rmOp(size), immOp(inst.Immediate(), 1)}
+	case op == 0xD0, op == 0xD1:
+		return shiftNames[digit], []operand{inst.rmOp(size), immOp(1, 1)}
+	case op == 0xD2, op == 0xD3:
+		return shiftNames[digit], []operand{inst.rmOp(size), regOp(RCX, 1, false)}
+	case op == 0xC3:
+		return "ret", nil
+	case op == 0xC6, op == 0xC7:
+		return "mov", []operand{inst.rmOp(size), inst.uimmOp()}
+	case op == 0xE2:
+		return "loop", []operand{inst.relOp()}
+	case op == 0xE8:
+		return "call", []operand{inst.relOp()}
+	case op == 0xE9, op == 0xEB:
+		return "jmp", []operand{inst.relOp()}
+	case op == 0xF6, op == 0xF7:
+		if digit <= 1 {
+			return "test", []operand{inst.rmOp(size), inst.uimmOp()}
+		}
+		return group3Names[digit], []operand{inst.rmOp(size)}
+	case op == 0xFE, op == 0xFF:
+		switch digit {
+		case 0:
+			return "inc", []operand{inst.rmOp(size)}
+		case 1:
+			return "dec", []operand{inst.rmOp(size)}
+		case 2:
+			return "call", []operand{inst.rmOp(size)}
+		case 4:
+			return "jmp", []operand{inst.rmOp(size)}
+		case 6:
+			return "push", []operand{inst.rmOp(size)}
+		}
+	}
+	return fmt.Sprintf("(bad %02x)", inst.Opcode), nil
+}
+
+func formatImm(op operand) string {
+	if op.unsigned {
+		return fmt.Sprintf("0x%x", uint64(op.imm)&sizeMask(op.size))
+	}
+	return formatHex(op.imm)
+}
+
+func formatHex(v int64) string {
+	if v < 0 {
+		return fmt.Sprintf("-0x%x", uint64(-v))
+	}
+	return fmt.Sprintf("0x%x", v)
+}
+
+var ptrNames = map[int]string{1: "byte", 2: "word", 4: "dword", 8: "qword"}
+
+// FormatIntel renders inst in Intel syntax, e.g. "add qword ptr [rbx+rcx*4+0x8], 0x5".
+func FormatIntel(inst *Instruction) string {
+	mnemonic, ops := describe(inst)
+	if len(ops) == 0 {
+		return mnemonic
+	}
+	parts := make([]string, len(ops))
+	for i, op := range ops {
+		parts[i] = intelOperand(op)
+	}
+	return mnemonic + " " + strings.Join(parts, ", ")
+}
+
+func intelOperand(op operand) string {
+	switch op.kind {
+	case opReg:
+		return RegisterName(op.reg, op.size, op.rex)
+	case opImm:
+		return formatImm(op)
+	case opRel:
+		return formatHex(op.imm)
+	}
+
+	inst := op.inst
+	var addr strings.Builder
+	if inst.RIPRel {
+		addr.WriteString("rip")
+	}
+	if base, ok := inst.BaseReg(); ok {
+		addr.WriteString(regNames64[base])
+	}
+	if index, ok := inst.IndexReg(); ok {
+		if addr.Len() > 0 {
+			addr.WriteByte('+')
+		}
+		fmt.Fprintf(&addr, "%s*%d", regNames64[index], inst.Scale())
+	}
+	if inst.DispSize > 0 && (inst.Disp != 0 || inst.RIPRel || addr.Len() == 0) {
+		d := formatHex(int64(inst.Disp))
+		if addr.Len() > 0 && inst.Disp >= 0 {
+			addr.WriteByte('+')
+		}
+		addr.WriteString(d)
+	}
+
+	if name, ok := ptrNames[op.size]; ok {
+		return fmt.Sprintf("%s ptr [%s]", name, addr.String())
+	}
+	return "[" + addr.String() + "]"
+}
+
+var attSuffix = map[int]string{1: "b", 2: "w", 4: "l", 8: "q"}
+
+// FormatATT renders inst in AT&T syntax, e.g. "addq $0x5,0x8(%rbx,%rcx,4)".
+// As in objdump, a size suffix is only added when no register operand
+// implies the size.
+func FormatATT(inst *Instruction) string {
+	mnemonic, ops := describe(inst)
+
+	hasReg := false
+	hasMem := false
+	for _, op := range ops {
+		hasReg = hasReg || op.kind == opReg
+		hasMem = hasMem || op.kind == opMem
+	}
+
+	switch mnemonic {
+	case "movzx", "movsx":
+		mnemonic = "mov" + mnemonic[3:4] + attSuffix[ops[1].size] + attSuffix[ops[0].size]
+	case "movsxd":
+		mnemonic = "movslq"
+	case "cwd":
+		mnemonic = "cwtd"
+	case "cdq":
+		mnemonic = "cltd"
+	case "cqo":
+		mnemonic = "cqto"
+	case "mov":
+		if hasMem && !hasReg {
+			mnemonic += attSuffix[inst.OpSize]
+		}
+	case "call", "jmp":
+		if len(ops) == 1 && ops[0].kind != opRel {
+			return mnemonic + " *" + attOperand(ops[0])
+		}
+	case "push", "pop":
+		if hasMem || len(ops) == 1 && ops[0].kind == opImm {
+			mnemonic += attSuffix[inst.OpSize]
+		}
+	case "lea", "nop", "ret", "loop":
+	default:
+		// SETcc only has a byte form, so objdump never suffixes it
+		if hasMem && !hasReg && !strings.HasPrefix(mnemonic, "set") {
+			mnemonic += attSuffix[inst.OpSize]
+		}
+	}
+
+	if len(ops) == 0 {
+		return mnemonic
+	}
+	parts := make([]string, len(ops))
+	for i, op := range ops {
+		parts[len(ops)-1-i] = attOperand(op)
+	}
+	return mnemonic + " " + strings.Join(parts, ",")
+}
+
+func attOperand(op operand) string {
+	switch op.kind {
+	case opReg:
+		return "%" + RegisterName(op.reg, op.size, op.rex)
+	case opImm:
+		return "$" + formatImm(op)
+	case opRel:
+		return formatHex(op.imm)
+	}
+
+	inst := op.inst
+	var sb strings.Builder
+	base, hasBase := inst.BaseReg()
+	index, hasIndex := inst.IndexReg()
+	if inst.DispSize > 0 && (inst.Disp != 0 || inst.RIPRel || !hasBase && !hasIndex) {
+		sb.WriteString(formatHex(int64(inst.Disp)))
+	}
+	if !inst.RIPRel && !hasBase && !hasIndex {
+		return sb.String()
+	}
+	sb.WriteByte('(')
+	if inst.RIPRel {
+		sb.WriteString("%rip")
+	}
+	if hasBase {
+		sb.WriteString("%" + regNames64[base])
+	}
+	if hasIndex {
+		fmt.Fprintf(&sb, ",%%%s,%d", regNames64[index], inst.Scale())
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
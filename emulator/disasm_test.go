@@ -0,0 +1,58 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		hex, intel, att string
+	}{
+		{"4801d8", "add rax, rbx", "add %rbx,%rax"},
+		{"4883c0fb", "add rax, -0x5", "add $-0x5,%rax"},
+		// mov and logical immediates print as unsigned bits at the operand size
+		{"48c7c0fbffffff", "mov rax, 0xfffffffffffffffb", "mov $0xfffffffffffffffb,%rax"},
+		{"83e0f0", "and eax, 0xfffffff0", "and $0xfffffff0,%eax"},
+		{"b0ff", "mov al, 0xff", "mov $0xff,%al"},
+		{"48a9ff000000", "test rax, 0xff", "test $0xff,%rax"},
+		{"488b44cb10", "mov rax, qword ptr [rbx+rcx*8+0x10]", "mov 0x10(%rbx,%rcx,8),%rax"},
+		{"488b0502000000", "mov rax, qword ptr [rip+0x2]", "mov 0x2(%rip),%rax"},
+		// a suffix only when no register gives the size
+		{"c6000a", "mov byte ptr [rax], 0xa", "movb $0xa,(%rax)"},
+		{"48c70005000000", "mov qword ptr [rax], 0x5", "movq $0x5,(%rax)"},
+		{"ff00", "inc dword ptr [rax]", "incl (%rax)"},
+		{"ff30", "push qword ptr [rax]", "pushq (%rax)"},
+		{"0f9400", "sete byte ptr [rax]", "sete (%rax)"},
+		{"0f94c0", "sete al", "sete %al"},
+		{"0fb6c3", "movzx eax, bl", "movzbl %bl,%eax"},
+		{"480fbec3", "movsx rax, bl", "movsbq %bl,%rax"},
+		{"4863c3", "movsxd rax, ebx", "movslq %ebx,%rax"},
+		{"99", "cdq", "cltd"},
+		{"4899", "cqo", "cqto"},
+		{"d3e0", "shl eax, cl", "shl %cl,%eax"},
+		{"eb00", "jmp 0x2", "jmp 0x2"},
+		{"ff10", "call qword ptr [rax]", "call *(%rax)"},
+		{"90", "nop", "nop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.intel, func(t *testing.T) {
+			code, err := ParseHexString(tt.hex)
+			require.NoError(t, err)
+			lines, err := Disassemble(code)
+			require.NoError(t, err)
+			require.Len(t, lines, 1)
+			require.Equal(t, tt.intel, lines[0].Intel)
+			require.Equal(t, tt.att, lines[0].ATT)
+		})
+	}
+}
+
+func TestDisassembleStopsAtBadOpcode(t *testing.T) {
+	// nop; then an opcode the decoder does not know
+	lines, err := Disassemble([]byte{0x90, 0x0F, 0xFF})
+	require.Error(t, err)
+	require.Len(t, lines, 1)
+	require.Equal(t, "nop", lines[0].Intel)
+}
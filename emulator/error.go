@@ -1,12 +1,37 @@
 package emulator
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+type ErrorKind int
+
+const (
+	KindGeneric ErrorKind = iota
+	KindStepLimit
+	KindJumpOutOfRange
+	KindOverflow
+	KindStackOverflow
+	KindStackUnderflow
+	KindDivideError
+	KindHalted
+)
 
 type EmulatorError struct {
 	PC      int
+	Kind    ErrorKind
 	Message string
 }
 
 func (e *EmulatorError) Error() string {
 	return fmt.Sprintf("Error at PC:%d %s", e.PC, e.Message)
 }
+
+func IsKind(err error, kind ErrorKind) bool {
+	var emuErr *EmulatorError
+	if !errors.As(err, &emuErr) {
+		return false
+	}
+	return emuErr.Kind == kind
+}
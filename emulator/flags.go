@@ -0,0 +1,138 @@
+package emulator
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+type Flag uint64
+
+const (
+	FlagCF Flag = 1 << 0
+	FlagPF Flag = 1 << 2
+	FlagAF Flag = 1 << 4
+	FlagZF Flag = 1 << 6
+	FlagSF Flag = 1 << 7
+	FlagOF Flag = 1 << 11
+)
+
+// bit 1 of RFLAGS is reserved and always reads as 1
+const rflagsReserved uint64 = 1 << 1
+
+func (f Flag) String() string {
+	switch f {
+	case FlagCF:
+		return "CF"
+	case FlagPF:
+		return "PF"
+	case FlagAF:
+		return "AF"
+	case FlagZF:
+		return "ZF"
+	case FlagSF:
+		return "SF"
+	case FlagOF:
+		return "OF"
+	default:
+		return "unknown"
+	}
+}
+
+func (cpu *CPU) GetFlags() uint64 {
+	return cpu.rflags
+}
+
+func (cpu *CPU) GetFlag(f Flag) bool {
+	return cpu.rflags&uint64(f) != 0
+}
+
+func (cpu *CPU) SetFlag(f Flag, on bool) {
+	if on {
+		cpu.rflags |= uint64(f)
+	} else {
+		cpu.rflags &^= uint64(f)
+	}
+}
+
+var displayFlags = []Flag{FlagCF, FlagPF, FlagAF, FlagZF, FlagSF, FlagOF}
+
+func (cpu *CPU) FlagsString() string {
+	var sb strings.Builder
+	for i, f := range displayFlags {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		v := 0
+		if cpu.GetFlag(f) {
+			v = 1
+		}
+		fmt.Fprintf(&sb, "%s=%d", f, v)
+	}
+	return sb.String()
+}
+
+func parityEven(v uint64) bool {
+	return bits.OnesCount8(uint8(v))%2 == 0
+}
+
+// The update helpers below take operands and results already truncated to
+// size bytes, so CF/OF/SF are taken at the operand width rather than at 64 bits.
+
+// setResultFlags sets SF, ZF and PF from a result and leaves the others alone.
+func (cpu *CPU) setResultFlags(result uint64, size int) {
+	cpu.SetFlag(FlagSF, result&signBit(size) != 0)
+	cpu.SetFlag(FlagZF, result&sizeMask(size) == 0)
+	cpu.SetFlag(FlagPF, parityEven(result))
+}
+
+func (cpu *CPU) updateFlagsAdd(a, b, result uint64, size int) {
+	cpu.setResultFlags(result, size)
+	cpu.SetFlag(FlagCF, result < a)
+	cpu.SetFlag(FlagOF, (a^result)&(b^result)&signBit(size) != 0)
+	cpu.SetFlag(FlagAF, (a^b^result)&0x10 != 0)
+}
+
+func (cpu *CPU) updateFlagsSub(a, b, result uint64, size int) {
+	cpu.setResultFlags(result, size)
+	cpu.SetFlag(FlagCF, a < b)
+	cpu.SetFlag(FlagOF, (a^b)&(a^result)&signBit(size) != 0)
+	cpu.SetFlag(FlagAF, (a^b^result)&0x10 != 0)
+}
+
+// updateFlagsLogic follows AND/OR/XOR: CF and OF are cleared, AF is
+// undefined on hardware and is cleared here.
+func (cpu *CPU) updateFlagsLogic(result uint64, size int) {
+	cpu.setResultFlags(result, size)
+	cpu.SetFlag(FlagCF, false)
+	cpu.SetFlag(FlagOF, false)
+	cpu.SetFlag(FlagAF, false)
+}
+
+// condition evaluates the 4-bit condition code shared by Jcc, SETcc and CMOVcc.
+func (cpu *CPU) condition(cc byte) bool {
+	var r bool
+	switch cc >> 1 {
+	case 0:
+		r = cpu.GetFlag(FlagOF)
+	case 1:
+		r = cpu.GetFlag(FlagCF)
+	case 2:
+		r = cpu.GetFlag(FlagZF)
+	case 3:
+		r = cpu.GetFlag(FlagCF) || cpu.GetFlag(FlagZF)
+	case 4:
+		r = cpu.GetFlag(FlagSF)
+	case 5:
+		r = cpu.GetFlag(FlagPF)
+	case 6:
+		r = cpu.GetFlag(FlagSF) != cpu.GetFlag(FlagOF)
+	case 7:
+		r = cpu.GetFlag(FlagZF) || cpu.GetFlag(FlagSF) != cpu.GetFlag(FlagOF)
+	}
+	// odd condition codes are the negation of the preceding even one
+	if cc&1 != 0 {
+		return !r
+	}
+	return r
+}
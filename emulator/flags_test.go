@@ -0,0 +1,95 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flagNames lists the status flags set in rflags, so failures read well.
+func flagNames(rflags uint64) []string {
+	var names []string
+	for _, f := range displayFlags {
+		if rflags&uint64(f) != 0 {
+			names = append(names, f.String())
+		}
+	}
+	return names
+}
+
+func TestArithmeticFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		hex   string
+		rax   int64
+		flags Flag
+	}{
+		// mov rax, -1; add rax, 1
+		{"add carries out", "48c7c0ffffffff480501000000", 0, FlagCF | FlagPF | FlagAF | FlagZF},
+		// mov rax, 0; sub rax, 1
+		{"sub borrows", "48c7c000000000482d01000000", -1, FlagCF | FlagPF | FlagAF | FlagSF},
+		// mov rax, 0x10; sub rax, 0x10
+		{"sub to zero", "48c7c010000000482d10000000", 0, FlagPF | FlagZF},
+		// mov rax, 0x0f; add rax, 1
+		{"add half carry", "48c7c00f000000480501000000", 0x10, FlagAF},
+		// mov rax, 3; mov rbx, 4; add rax, rbx
+		{"add reg", "48c7c00300000048c7c3040000004801d8", 7, 0},
+		// mov eax, -1; add eax, 1 carries out of bit 31
+		{"32-bit add carries out", "b8ffffffff0501000000", 0, FlagCF | FlagPF | FlagAF | FlagZF},
+		// mov al, 0xff; mov cl, 1; add al, cl carries out of bit 7
+		{"8-bit add carries out", "b0ffb10100c8", 0, FlagCF | FlagPF | FlagAF | FlagZF},
+		// mov ax, 0; sub ax, 1 takes SF from bit 15
+		{"16-bit borrow", "66b80000662d0100", 0xffff, FlagCF | FlagPF | FlagAF | FlagSF},
+		// mov rax, -1; add rax, 1; xor rax, rax clears CF and AF
+		{"xor clears CF", "48c7c0ffffffff4805010000004831c0", 0, FlagPF | FlagZF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+			require.Equal(t, flagNames(uint64(tt.flags)), flagNames(cpu.GetFlags()))
+		})
+	}
+}
+
+func TestOverflowSetsOF(t *testing.T) {
+	// movabs rax, 0x7fffffffffffffff; add rax, 1
+	cpu, err := runHex(t, "48b8ffffffffffffff7f480501000000")
+	require.Error(t, err)
+	require.True(t, cpu.GetFlag(FlagOF))
+	require.True(t, cpu.GetFlag(FlagSF))
+}
+
+// CMP and TEST set the flags of SUB and AND without writing the result.
+func TestCompareFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		hex   string
+		rax   int64
+		flags Flag
+	}{
+		// mov rax, 5; cmp rax, 5
+		{"cmp equal", "48c7c005000000483d05000000", 5, FlagPF | FlagZF},
+		// mov rax, 3; cmp rax, 5
+		{"cmp below", "48c7c003000000483d05000000", 3, FlagCF | FlagAF | FlagSF},
+		// mov rax, 5; mov rbx, 3; cmp rax, rbx
+		{"cmp reg above", "48c7c00500000048c7c3030000004839d8", 5, 0},
+		// mov rax, 5; mov rbx, 3; cmp rbx, rax
+		{"cmp reg with 3B", "48c7c00500000048c7c303000000483bd8", 5, FlagCF | FlagAF | FlagSF},
+		// mov rax, -1; test rax, rax
+		{"test negative", "48c7c0ffffffff4885c0", -1, FlagPF | FlagSF},
+		// mov rax, 0x7f; test rax, 0x80
+		{"test disjoint", "48c7c07f00000048a980000000", 0x7f, FlagPF | FlagZF},
+		// mov rax, -1; add rax, 1; mov rbx, 0x10; test rbx, 0x10 clears CF
+		{"test r/m imm", "48c7c0ffffffff48050100000048c7c31000000048f7c310000000", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+			require.Equal(t, flagNames(uint64(tt.flags)), flagNames(cpu.GetFlags()))
+		})
+	}
+}
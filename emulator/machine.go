@@ -0,0 +1,230 @@
+package emulator
+
+import "fmt"
+
+// RegisterState is the architectural register file at one point in time.
+type RegisterState struct {
+	Registers [NumRegisters]int64
+	RFlags    uint64
+	PC        int
+}
+
+func (cpu *CPU) RegisterState() RegisterState {
+	return RegisterState{Registers: cpu.registers, RFlags: cpu.rflags, PC: cpu.pc}
+}
+
+func (cpu *CPU) setRegisterState(s RegisterState) {
+	cpu.registers = s.Registers
+	cpu.rflags = s.RFlags
+	cpu.pc = s.PC
+	cpu.accesses = nil
+}
+
+// Snapshot is a copy of the full CPU state: registers, flags, PC and memory.
+type Snapshot struct {
+	State  RegisterState
+	memory *Memory
+}
+
+func (cpu *CPU) Snapshot() *Snapshot {
+	return &Snapshot{State: cpu.RegisterState(), memory: cpu.memory.Clone()}
+}
+
+// Restore puts the CPU back into the state captured by s. s stays valid and
+// can be restored again.
+func (cpu *CPU) Restore(s *Snapshot) {
+	cpu.setRegisterState(s.State)
+	cpu.memory = s.memory.Clone()
+}
+
+// TraceEntry records one executed instruction.
+type TraceEntry struct {
+	Step   int
+	PC     int
+	Bytes  []byte
+	Intel  string
+	Before RegisterState
+	After  RegisterState
+	Memory []MemoryAccess
+}
+
+// ChangedRegisters lists the registers whose value differs between Before
+// and After.
+func (e *TraceEntry) ChangedRegisters() []Register {
+	var regs []Register
+	for i := 0; i < NumRegisters; i++ {
+		if e.Before.Registers[i] != e.After.Registers[i] {
+			regs = append(regs, Register(i))
+		}
+	}
+	return regs
+}
+
+// Machine is a CPU with a program loaded, executed one instruction at a
+// time by Step or to completion by Run.
+type Machine struct {
+	cpu     *CPU
+	code    []byte
+	decoder *Decoder
+	steps   int
+	trace   bool
+	history []TraceEntry
+
+	breakpoints map[int]bool
+	watches     []watch
+
+	// undo holds, per executed instruction, what StepBack needs to revert it
+	undo    []undoEntry
+	initial *Snapshot
+}
+
+type undoEntry struct {
+	before RegisterState
+	writes []MemoryAccess
+}
+
+// NewMachine loads code into cpu, mapping it into memory at address 0 so
+// RIP-relative operands can read inline data, and points the PC at its first
+// byte. The registers and the rest of memory are left as they are.
+func NewMachine(cpu *CPU, code []byte) *Machine {
+	cpu.pc = 0
+	cpu.memory.WriteBytes(0, code)
+	return &Machine{cpu: cpu, code: code, decoder: NewDecoder(code), initial: cpu.Snapshot()}
+}
+
+// Reset returns the CPU to the state it was in when the program was loaded
+// and forgets the executed instructions. Breakpoints and watchpoints stay.
+func (m *Machine) Reset() {
+	m.cpu.Restore(m.initial)
+	m.steps = 0
+	m.history = nil
+	m.undo = nil
+	m.refreshWatches()
+}
+
+func (m *Machine) CPU() *CPU {
+	return m.cpu
+}
+
+func (m *Machine) Code() []byte {
+	return m.code
+}
+
+// Steps returns the number of instructions executed so far.
+func (m *Machine) Steps() int {
+	return m.steps
+}
+
+// SetTrace turns recording of every executed instruction on or off.
+func (m *Machine) SetTrace(on bool) {
+	m.trace = on
+}
+
+func (m *Machine) Trace() []TraceEntry {
+	return m.history
+}
+
+// Done reports whether the PC has reached the end of the code, which is how
+// a program terminates.
+func (m *Machine) Done() bool {
+	return m.cpu.pc >= len(m.code)
+}
+
+// Step executes the instruction at the PC and describes what it did. It is
+// an error to step a finished program or to exceed the CPU's step limit.
+func (m *Machine) Step() (TraceEntry, error) {
+	cpu := m.cpu
+	if m.Done() {
+		return TraceEntry{}, &EmulatorError{PC: cpu.pc, Kind: KindHalted, Message: "program has finished"}
+	}
+	if m.steps >= cpu.maxSteps {
+		return TraceEntry{}, &EmulatorError{PC: cpu.pc, Kind: KindStepLimit, Message: fmt.Sprintf("step limit exceeded (%d instructions)", cpu.maxSteps)}
+	}
+	if err := m.decoder.Seek(cpu.pc); err != nil {
+		return TraceEntry{}, err
+	}
+	inst, err := m.decoder.DecodeNext()
+	if err != nil {
+		return TraceEntry{}, err
+	}
+
+	entry := TraceEntry{
+		Step:   m.steps + 1,
+		PC:     cpu.pc,
+		Bytes:  m.code[inst.Offset : inst.Offset+inst.Length],
+		Intel:  FormatIntel(inst),
+		Before: cpu.RegisterState(),
+	}
+	execErr := cpu.Execute(inst)
+	m.steps++
+	entry.After = cpu.RegisterState()
+	entry.Memory = cpu.LastMemoryAccesses()
+
+	undo := undoEntry{before: entry.Before}
+	for _, a := range entry.Memory {
+		if a.Write {
+			undo.writes = append(undo.writes, a)
+		}
+	}
+	m.undo = append(m.undo, undo)
+	if m.trace {
+		m.history = append(m.history, entry)
+	}
+	if execErr != nil {
+		return entry, execErr
+	}
+
+	if cpu.pc < 0 || cpu.pc > len(m.code) {
+		return entry, &EmulatorError{PC: entry.PC, Kind: KindJumpOutOfRange, Message: fmt.Sprintf("jump target %d out of range", cpu.pc)}
+	}
+	return entry, nil
+}
+
+// Run steps until the PC falls off the end of the code, following branches
+// and stopping after the CPU's step limit.
+func (m *Machine) Run() error {
+	for !m.Done() {
+		if _, err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StepBack reverts the most recently executed instruction, restoring the
+// registers, flags, PC and any memory it wrote.
+func (m *Machine) StepBack() error {
+	if len(m.undo) == 0 {
+		return &EmulatorError{PC: m.cpu.pc, Message: "no instruction to step back over"}
+	}
+	last := m.undo[len(m.undo)-1]
+	m.undo = m.undo[:len(m.undo)-1]
+
+	for i := len(last.writes) - 1; i >= 0; i-- {
+		w := last.writes[i]
+		m.cpu.memory.Write(w.Addr, w.Size, w.Old)
+	}
+	m.cpu.setRegisterState(last.before)
+	m.steps--
+	if m.trace && len(m.history) > 0 {
+		m.history = m.history[:len(m.history)-1]
+	}
+	m.refreshWatches()
+	return nil
+}
+
+// Seek steps forwards or backwards until step instructions have executed,
+// stopping early if the program finishes or fails.
+func (m *Machine) Seek(step int) error {
+	for m.steps > step {
+		if err := m.StepBack(); err != nil {
+			return err
+		}
+	}
+	for m.steps < step && !m.Done() {
+		if _, err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMachineMapsCodeForRIPRelativeLoads(t *testing.T) {
+	// mov rax, [rip+2]; jmp +8; dq 0x1122334455667788
+	code, err := ParseHexString("488b0502000000eb088877665544332211")
+	require.NoError(t, err)
+
+	cpu := NewCPU(DefaultConfig())
+	require.NoError(t, NewMachine(cpu, code).Run())
+	require.Equal(t, int64(0x1122334455667788), cpu.GetRegister(RAX))
+}
+
+func TestMachineTrace(t *testing.T) {
+	// mov rax, 5; push rax; pop rbx
+	code, err := ParseHexString("48c7c005000000505b")
+	require.NoError(t, err)
+
+	m := NewMachine(NewCPU(DefaultConfig()), code)
+	m.SetTrace(true)
+	require.NoError(t, m.Run())
+	require.Equal(t, 3, m.Steps())
+
+	trace := m.Trace()
+	require.Len(t, trace, 3)
+	tests := []struct {
+		pc      int
+		intel   string
+		changed []Register
+		memory  []MemoryAccess
+	}{
+		{0, "mov rax, 0x5", []Register{RAX}, nil},
+		{7, "push rax", []Register{RSP}, []MemoryAccess{{Addr: StackTop - 8, Size: 8, Value: 5, Write: true}}},
+		{8, "pop rbx", []Register{RBX, RSP}, []MemoryAccess{{Addr: StackTop - 8, Size: 8, Value: 5}}},
+	}
+	for i, tt := range tests {
+		e := trace[i]
+		require.Equal(t, i+1, e.Step)
+		require.Equal(t, tt.pc, e.PC)
+		require.Equal(t, tt.intel, e.Intel)
+		require.Equal(t, tt.changed, e.ChangedRegisters())
+		require.Equal(t, tt.memory, e.Memory)
+		require.Equal(t, tt.pc, e.Before.PC)
+		require.Equal(t, tt.pc+len(e.Bytes), e.After.PC)
+	}
+}
+
+func TestMachineStep(t *testing.T) {
+	// mov eax, 1
+	code, err := ParseHexString("b801000000")
+	require.NoError(t, err)
+
+	m := NewMachine(NewCPU(DefaultConfig()), code)
+	entry, err := m.Step()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), entry.After.Registers[RAX])
+	require.True(t, m.Done())
+	// tracing is off by default
+	require.Empty(t, m.Trace())
+
+	_, err = m.Step()
+	require.True(t, IsKind(err, KindHalted), "got %v", err)
+}
+
+func TestMachineResetKeepsCodeMapped(t *testing.T) {
+	// mov [rip-8], al overwrites the first byte of the program
+	code, err := ParseHexString("b0058805f8ffffff")
+	require.NoError(t, err)
+
+	cpu := NewCPU(DefaultConfig())
+	m := NewMachine(cpu, code)
+	require.NoError(t, m.Run())
+	require.Equal(t, byte(0x05), cpu.Memory().ByteAt(0))
+
+	m.Reset()
+	require.Equal(t, byte(0xb0), cpu.Memory().ByteAt(0))
+}
+
+func TestStepBackAndSeek(t *testing.T) {
+	// mov rax, 0x11; mov [0x200], rax; mov rax, 0x22; mov [0x200], rax
+	code, err := ParseHexString("48c7c011000000488904250002000048c7c0220000004889042500020000")
+	require.NoError(t, err)
+
+	cpu := NewCPU(DefaultConfig())
+	m := NewMachine(cpu, code)
+	require.NoError(t, m.Run())
+	require.Equal(t, uint64(0x22), cpu.Memory().Read(0x200, 8))
+
+	require.NoError(t, m.StepBack())
+	require.Equal(t, 3, m.Steps())
+	require.Equal(t, uint64(0x11), cpu.Memory().Read(0x200, 8))
+	require.Equal(t, int64(0x22), cpu.GetRegister(RAX))
+
+	require.NoError(t, m.Seek(1))
+	require.Equal(t, uint64(0), cpu.Memory().Read(0x200, 8))
+	require.Equal(t, int64(0x11), cpu.GetRegister(RAX))
+	require.Equal(t, 7, cpu.RegisterState().PC)
+
+	require.NoError(t, m.Seek(4))
+	require.True(t, m.Done())
+	require.Equal(t, uint64(0x22), cpu.Memory().Read(0x200, 8))
+
+	require.NoError(t, m.Seek(0))
+	require.Equal(t, int64(0), cpu.GetRegister(RAX))
+	require.Equal(t, 0, cpu.RegisterState().PC)
+	require.Error(t, m.StepBack())
+}
@@ -0,0 +1,79 @@
+package emulator
+
+import "sort"
+
+// Memory is a sparse, byte-addressable, little-endian address space.
+// Bytes that were never written read as zero.
+type Memory struct {
+	bytes map[uint64]byte
+}
+
+type MemoryAccess struct {
+	Addr  uint64
+	Size  int
+	Value uint64
+	Write bool
+	Old   uint64 // the overwritten value, for writes
+}
+
+func NewMemory() *Memory {
+	return &Memory{bytes: make(map[uint64]byte)}
+}
+
+func (m *Memory) Clone() *Memory {
+	c := NewMemory()
+	for a, v := range m.bytes {
+		c.bytes[a] = v
+	}
+	return c
+}
+
+func (m *Memory) ByteAt(addr uint64) byte {
+	return m.bytes[addr]
+}
+
+func (m *Memory) SetByte(addr uint64, v byte) {
+	if v == 0 {
+		delete(m.bytes, addr)
+		return
+	}
+	m.bytes[addr] = v
+}
+
+func (m *Memory) Read(addr uint64, size int) uint64 {
+	var v uint64
+	for i := 0; i < size; i++ {
+		v |= uint64(m.ByteAt(addr+uint64(i))) << (8 * i)
+	}
+	return v
+}
+
+func (m *Memory) Write(addr uint64, size int, v uint64) {
+	for i := 0; i < size; i++ {
+		m.SetByte(addr+uint64(i), byte(v>>(8*i)))
+	}
+}
+
+func (m *Memory) ReadBytes(addr uint64, n int) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = m.ByteAt(addr + uint64(i))
+	}
+	return out
+}
+
+func (m *Memory) WriteBytes(addr uint64, b []byte) {
+	for i, v := range b {
+		m.SetByte(addr+uint64(i), v)
+	}
+}
+
+// Addresses returns the addresses holding non-zero bytes in ascending order.
+func (m *Memory) Addresses() []uint64 {
+	addrs := make([]uint64, 0, len(m.bytes))
+	for a := range m.bytes {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
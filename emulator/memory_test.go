@@ -0,0 +1,60 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressing(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		addr uint64
+		size int
+		want uint64
+	}{
+		// mov rbx, 0x100; mov rcx, 2; mov rax, 0x2a; mov [rbx+rcx*8+0x10], rax
+		{"base, index and disp8", "48c7c30001000048c7c10200000048c7c02a000000488944cb10", 0x120, 8, 0x2a},
+		// mov rax, -1; mov [0x200], rax
+		{"SIB without base", "48c7c0ffffffff4889042500020000", 0x200, 8, 0xffffffffffffffff},
+		// mov rbp, 0x300; mov eax, 7; mov [rbp+0], eax
+		{"rbp needs a disp8", "48c7c500030000b807000000894500", 0x300, 4, 7},
+		// mov r13, 0x400; mov r12, 1; mov eax, 9; mov [r13+r12*4+0], eax
+		{"REX.X and REX.B", "49c7c50004000049c7c401000000b809000000438944a500", 0x404, 4, 9},
+		// mov rsp, 0x500; mov al, 0x5a; mov [rsp], al
+		{"rsp base needs a SIB", "48c7c400050000b05a880424", 0x500, 1, 0x5a},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, cpu.Memory().Read(tt.addr, tt.size))
+		})
+	}
+}
+
+func TestMemoryLoads(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		reg  Register
+		want int64
+	}{
+		// mov rbx, 0x100; mov rcx, 2; mov rax, 0x2a; mov [rbx+rcx*8+0x10], rax; mov rdx, [rbx+rcx*8+0x10]
+		{"load back a store", "48c7c30001000048c7c10200000048c7c02a000000488944cb10488b54cb10", RDX, 0x2a},
+		// mov rax, [rip+2]; jmp +8; dq 0x1122334455667788
+		{"RIP-relative inline data", "488b0502000000eb088877665544332211", RAX, 0x1122334455667788},
+		// mov eax, [rip-0x6] reads its own encoding
+		{"RIP-relative backwards", "8b05faffffff", RAX, 0xfffa058b},
+		// mov rdx, 5; mov rdx, [0x300] of untouched memory
+		{"unwritten memory reads 0", "48c7c205000000488b142500030000", RDX, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, cpu.GetRegister(tt.reg))
+		})
+	}
+}
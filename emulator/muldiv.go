@@ -0,0 +1,194 @@
+package emulator
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// mulSigned returns the low size bytes of a*b for operands of the given size
+// together with whether the full signed product did not fit.
+func mulSigned(a, b uint64, size int) (uint64, bool) {
+	if size < 8 {
+		p := signExtend(a, size) * signExtend(b, size)
+		result := uint64(p) & sizeMask(size)
+		return result, signExtend(result, size) != p
+	}
+	hi, lo := mulSigned128(a, b)
+	return lo, hi != uint64(int64(lo)>>63)
+}
+
+// mulSigned128 returns the 128-bit two's complement product of a and b.
+func mulSigned128(a, b uint64) (hi, lo uint64) {
+	hi, lo = bits.Mul64(a, b)
+	if int64(a) < 0 {
+		hi -= b
+	}
+	if int64(b) < 0 {
+		hi -= a
+	}
+	return hi, lo
+}
+
+// imul is the two- and three-operand IMUL: the product is truncated to the
+// operand size and CF/OF report whether anything was lost.
+func (cpu *CPU) imul(a, b uint64, size int) (uint64, error) {
+	width := cpu.arithWidth(size)
+	result, overflow := mulSigned(a, b, width)
+	cpu.SetFlag(FlagCF, overflow)
+	cpu.SetFlag(FlagOF, overflow)
+	return cpu.arithResult("IMUL", result, size)
+}
+
+// accumulatorPair reads the implicit double-width operand of the one-operand
+// multiply and divide forms: AH:AL for bytes, rDX:rAX otherwise.
+func (cpu *CPU) accumulatorPair(size int) (hi, lo uint64) {
+	if size == 1 {
+		ax := cpu.readReg(RAX, 2, false)
+		return ax >> 8, ax & 0xFF
+	}
+	return cpu.readReg(RDX, size, false), cpu.readReg(RAX, size, false)
+}
+
+func (cpu *CPU) setAccumulatorPair(hi, lo uint64, size int) {
+	if size == 1 {
+		cpu.writeReg(RAX, 2, false, (hi&0xFF)<<8|lo&0xFF)
+		return
+	}
+	cpu.writeReg(RAX, size, false, lo)
+	cpu.writeReg(RDX, size, false, hi)
+}
+
+// mulWide is the one-operand MUL/IMUL: rDX:rAX = rAX * src. The full product
+// is always kept, so the overflow policy does not apply.
+func (cpu *CPU) mulWide(src uint64, size int, signed bool) {
+	_, a := cpu.accumulatorPair(size)
+	mask := sizeMask(size)
+	bitsN := uint(size) * 8
+
+	var hi, lo uint64
+	switch {
+	case size == 8 && signed:
+		hi, lo = mulSigned128(a, src)
+	case size == 8:
+		hi, lo = bits.Mul64(a, src)
+	case signed:
+		p := uint64(signExtend(a, size) * signExtend(src, size))
+		hi, lo = p>>bitsN&mask, p&mask
+	default:
+		p := (a & mask) * (src & mask)
+		hi, lo = p>>bitsN&mask, p&mask
+	}
+	cpu.setAccumulatorPair(hi, lo, size)
+
+	var lost bool
+	if signed {
+		lost = hi != uint64(signExtend(lo, size)>>63)&mask
+	} else {
+		lost = hi != 0
+	}
+	cpu.SetFlag(FlagCF, lost)
+	cpu.SetFlag(FlagOF, lost)
+}
+
+func (cpu *CPU) divideError(msg string) error {
+	return &EmulatorError{PC: cpu.pc, Kind: KindDivideError, Message: "#DE " + msg}
+}
+
+// divWide is the one-operand DIV/IDIV: rAX = rDX:rAX / src, rDX = remainder.
+// A zero divisor or a quotient that does not fit raises #DE.
+func (cpu *CPU) divWide(src uint64, size int, signed bool) error {
+	mask := sizeMask(size)
+	src &= mask
+	if src == 0 {
+		return cpu.divideError("divide by zero")
+	}
+	hi, lo := cpu.accumulatorPair(size)
+
+	var q, r uint64
+	var ok bool
+	if size == 8 {
+		q, r, ok = div128(hi, lo, src, signed)
+	} else {
+		q, r, ok = div64(hi<<(uint(size)*8)|lo, src, size, signed)
+	}
+	if !ok {
+		name := "DIV"
+		if signed {
+			name = "IDIV"
+		}
+		return cpu.divideError(fmt.Sprintf("quotient overflow in %s", name))
+	}
+	cpu.setAccumulatorPair(r, q, size)
+	return nil
+}
+
+// div64 divides a double-width dividend of at most 64 bits by src.
+func div64(dividend, src uint64, size int, signed bool) (q, r uint64, ok bool) {
+	mask := sizeMask(size)
+	if !signed {
+		q, r = dividend/src, dividend%src
+		return q & mask, r & mask, q <= mask
+	}
+	n := signExtend(dividend, size*2)
+	d := signExtend(src, size)
+	sq, sr := n/d, n%d
+	lim := int64(signBit(size))
+	if sq < -lim || sq >= lim {
+		return 0, 0, false
+	}
+	return uint64(sq) & mask, uint64(sr) & mask, true
+}
+
+func div128(hi, lo, src uint64, signed bool) (q, r uint64, ok bool) {
+	if !signed {
+		if hi >= src {
+			return 0, 0, false
+		}
+		q, r = bits.Div64(hi, lo, src)
+		return q, r, true
+	}
+
+	negN := int64(hi) < 0
+	if negN {
+		hi, lo = neg128(hi, lo)
+	}
+	negD := int64(src) < 0
+	if negD {
+		src = -src
+	}
+	if hi >= src {
+		return 0, 0, false
+	}
+	q, r = bits.Div64(hi, lo, src)
+
+	if negN != negD {
+		if q > 1<<63 {
+			return 0, 0, false
+		}
+		q = -q
+	} else if q >= 1<<63 {
+		return 0, 0, false
+	}
+	if negN {
+		r = -r
+	}
+	return q, r, true
+}
+
+func neg128(hi, lo uint64) (uint64, uint64) {
+	lo = ^lo + 1
+	hi = ^hi
+	if lo == 0 {
+		hi++
+	}
+	return hi, lo
+}
+
+// signExtendAccumulator is CWD/CDQ/CQO: fill rDX with the sign of rAX.
+func (cpu *CPU) signExtendAccumulator(size int) {
+	var v uint64
+	if cpu.readReg(RAX, size, false)&signBit(size) != 0 {
+		v = sizeMask(size)
+	}
+	cpu.writeReg(RDX, size, false, v)
+}
@@ -0,0 +1,54 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMulDiv(t *testing.T) {
+	tests := []struct {
+		name     string
+		hex      string
+		rax, rdx int64
+	}{
+		// mov rax, 6; mov rcx, 7; imul rax, rcx
+		{"imul", "48c7c00600000048c7c107000000480fafc1", 42, 0},
+		// mov rcx, -3; imul rax, rcx, 5
+		{"imul imm", "48c7c1fdffffff486bc105", -15, 0},
+		// mov rax, -1; mov rcx, 2; mul rcx
+		{"mul", "48c7c0ffffffff48c7c10200000048f7e1", -2, 1},
+		// mov rax, -7; cqo; mov rcx, 2; idiv rcx
+		{"idiv", "48c7c0f9ffffff489948c7c10200000048f7f9", -3, -1},
+		// mov rax, 7; mov rdx, 0; mov rcx, 2; div rcx
+		{"div", "48c7c00700000048c7c20000000048c7c10200000048f7f1", 3, 1},
+		// mov eax, -1; cdq
+		{"cdq", "b8ffffffff99", 0xffffffff, 0xffffffff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHexPolicy(t, tt.hex, OverflowWrap)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+			require.Equal(t, tt.rdx, cpu.GetRegister(RDX))
+		})
+	}
+}
+
+func TestDivideError(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		// mov rcx, 0; div rcx
+		{"divide by zero", "48c7c10000000048f7f1"},
+		// mov rdx, 1; mov rcx, 1; div rcx (quotient needs 65 bits)
+		{"quotient overflow", "48c7c20100000048c7c10100000048f7f1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runHexPolicy(t, tt.hex, OverflowWrap)
+			require.True(t, IsKind(err, KindDivideError), "got %v", err)
+		})
+	}
+}
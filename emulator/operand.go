@@ -0,0 +1,112 @@
+package emulator
+
+func sizeMask(size int) uint64 {
+	if size >= 8 {
+		return ^uint64(0)
+	}
+	return 1<<(uint(size)*8) - 1
+}
+
+func signBit(size int) uint64 {
+	return 1 << (uint(size)*8 - 1)
+}
+
+func signExtend(v uint64, size int) int64 {
+	shift := 64 - uint(size)*8
+	return int64(v<<shift) >> shift
+}
+
+// isHighByteReg reports whether an 8-bit register code names AH, CH, DH or
+// BH, which is the case for codes 4-7 only when no REX prefix is present.
+func isHighByteReg(reg Register, size int, rex bool) bool {
+	return size == 1 && !rex && reg >= RSP && reg <= RDI
+}
+
+func (cpu *CPU) readReg(reg Register, size int, rex bool) uint64 {
+	if isHighByteReg(reg, size, rex) {
+		return uint64(cpu.registers[reg-4]) >> 8 & 0xFF
+	}
+	return uint64(cpu.registers[reg]) & sizeMask(size)
+}
+
+// writeReg follows the x86-64 rules: a 32-bit write zero-extends into the
+// full register, 8- and 16-bit writes leave the upper bits untouched.
+func (cpu *CPU) writeReg(reg Register, size int, rex bool, v uint64) {
+	if isHighByteReg(reg, size, rex) {
+		r := uint64(cpu.registers[reg-4])
+		cpu.registers[reg-4] = int64(r&^0xFF00 | (v&0xFF)<<8)
+		return
+	}
+
+	switch size {
+	case 8:
+		cpu.registers[reg] = int64(v)
+	case 4:
+		cpu.registers[reg] = int64(uint32(v))
+	default:
+		mask := sizeMask(size)
+		r := uint64(cpu.registers[reg])
+		cpu.registers[reg] = int64(r&^mask | v&mask)
+	}
+}
+
+func (cpu *CPU) readRegField(inst *Instruction) uint64 {
+	return cpu.readReg(GetRegFromModRM(inst.ModRM, inst.Rex, false), inst.OpSize, inst.HasRex)
+}
+
+func (cpu *CPU) writeRegField(inst *Instruction, v uint64) {
+	cpu.writeReg(GetRegFromModRM(inst.ModRM, inst.Rex, false), inst.OpSize, inst.HasRex, v)
+}
+
+// effectiveAddress computes base + index*scale + disp for a memory operand.
+// RIP-relative addresses are relative to the end of the instruction.
+func (cpu *CPU) effectiveAddress(inst *Instruction) uint64 {
+	addr := uint64(int64(inst.Disp))
+	if inst.RIPRel {
+		return addr + uint64(cpu.pc+inst.Length)
+	}
+	if base, ok := inst.BaseReg(); ok {
+		addr += uint64(cpu.registers[base])
+	}
+	if index, ok := inst.IndexReg(); ok {
+		addr += uint64(cpu.registers[index]) * uint64(inst.Scale())
+	}
+	return addr
+}
+
+func (cpu *CPU) readMemory(addr uint64, size int) uint64 {
+	v := cpu.memory.Read(addr, size)
+	cpu.accesses = append(cpu.accesses, MemoryAccess{Addr: addr, Size: size, Value: v})
+	return v
+}
+
+func (cpu *CPU) writeMemory(addr uint64, size int, v uint64) {
+	v &= sizeMask(size)
+	old := cpu.memory.Read(addr, size)
+	cpu.memory.Write(addr, size, v)
+	cpu.accesses = append(cpu.accesses, MemoryAccess{Addr: addr, Size: size, Value: v, Write: true, Old: old})
+}
+
+func (cpu *CPU) readRM(inst *Instruction) uint64 {
+	return cpu.readRMSize(inst, inst.OpSize)
+}
+
+func (cpu *CPU) readRMSize(inst *Instruction, size int) uint64 {
+	if inst.IsMemory() {
+		return cpu.readMemory(cpu.effectiveAddress(inst), size)
+	}
+	return cpu.readReg(GetRegFromModRM(inst.ModRM, inst.Rex, true), size, inst.HasRex)
+}
+
+func (cpu *CPU) writeRM(inst *Instruction, v uint64) {
+	if inst.IsMemory() {
+		cpu.writeMemory(cpu.effectiveAddress(inst), inst.OpSize, v)
+		return
+	}
+	cpu.writeReg(GetRegFromModRM(inst.ModRM, inst.Rex, true), inst.OpSize, inst.HasRex, v)
+}
+
+// immediate returns the instruction's immediate truncated to its operand size.
+func (cpu *CPU) immediate(inst *Instruction) uint64 {
+	return uint64(inst.Immediate()) & sizeMask(inst.OpSize)
+}
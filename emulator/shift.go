@@ -0,0 +1,85 @@
+package emulator
+
+import "fmt"
+
+// shift implements the 0xC0/0xC1/0xD0-0xD3 group selected by the ModRM reg
+// field. The count is masked to 5 bits (6 for 64-bit operands); a masked
+// count of 0 leaves both the operand and the flags unchanged. OF is only
+// defined for 1-bit shifts and is left alone otherwise.
+func (cpu *CPU) shift(subOpcode byte, v, count uint64, size int) (uint64, error) {
+	if size == 8 {
+		count &= 0x3F
+	} else {
+		count &= 0x1F
+	}
+	mask := sizeMask(size)
+	v &= mask
+	if count == 0 {
+		return v, nil
+	}
+
+	width := uint64(size) * 8
+	msb := signBit(size)
+	var result uint64
+
+	switch subOpcode {
+	case 0: // ROL
+		c := count % width
+		result = (v<<c | v>>(width-c)) & mask
+		cf := result&1 != 0
+		cpu.SetFlag(FlagCF, cf)
+		if count == 1 {
+			cpu.SetFlag(FlagOF, (result&msb != 0) != cf)
+		}
+		return result, nil
+
+	case 1: // ROR
+		c := count % width
+		result = (v>>c | v<<(width-c)) & mask
+		cpu.SetFlag(FlagCF, result&msb != 0)
+		if count == 1 {
+			cpu.SetFlag(FlagOF, (result&msb != 0) != (result&(msb>>1) != 0))
+		}
+		return result, nil
+
+	case 4, 6: // SHL/SAL
+		cf := false
+		if count <= width {
+			result = v << count & mask
+			cf = v>>(width-count)&1 != 0
+		}
+		cpu.SetFlag(FlagCF, cf)
+		if count == 1 {
+			cpu.SetFlag(FlagOF, (result&msb != 0) != cf)
+		}
+
+	case 5: // SHR
+		cf := false
+		if count <= width {
+			result = v >> count
+			cf = v>>(count-1)&1 != 0
+		}
+		cpu.SetFlag(FlagCF, cf)
+		if count == 1 {
+			cpu.SetFlag(FlagOF, v&msb != 0)
+		}
+
+	case 7: // SAR
+		sv := signExtend(v, size)
+		if count >= width {
+			count = width
+		}
+		result = uint64(sv>>count) & mask
+		cpu.SetFlag(FlagCF, sv>>(count-1)&1 != 0)
+		if count == 1 {
+			cpu.SetFlag(FlagOF, false)
+		}
+
+	default:
+		return 0, fmt.Errorf("unsupported shift subopcode: %d", subOpcode)
+	}
+
+	cpu.setResultFlags(result, size)
+	cpu.SetFlag(FlagAF, false)
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitwise(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		rax  int64
+	}{
+		// mov rax, 0; not rax
+		{"not", "48c7c00000000048f7d0", -1},
+		// mov eax, 5; neg eax
+		{"neg", "b805000000f7d8", 0xfffffffb},
+		// mov rax, 0xf0; mov rcx, 0x0f; or rax, rcx
+		{"or", "48c7c0f000000048c7c10f0000004809c8", 0xff},
+		// mov eax, 0x1234; and eax, 0xff
+		{"and", "b83412000025ff000000", 0x34},
+		// mov rax, -16; sar rax, 2
+		{"sar", "48c7c0f0ffffff48c1f802", -4},
+		// mov eax, -16; shr eax, 4
+		{"shr", "b8f0ffffffc1e804", 0x0fffffff},
+		// mov eax, 3; mov cl, 4; shl eax, cl
+		{"shl by cl", "b803000000b104d3e0", 0x30},
+		// mov al, 0x81; rol al, 1
+		{"rol", "b081d0c0", 0x03},
+		// mov al, 0x81; ror al, 1
+		{"ror", "b081d0c8", 0xc0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.rax, cpu.GetRegister(RAX))
+		})
+	}
+}
+
+func TestBitwiseFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		hex   string
+		flags Flag
+	}{
+		// mov eax, -1; add eax, 1; or eax, 0
+		{"logic clears CF", "b8ffffffff050100000083c800", FlagPF | FlagZF},
+		// mov eax, 0x80000001; shl eax, 1
+		{"shl shifts out into CF", "b801000080d1e0", FlagCF | FlagOF},
+		// mov eax, 5; neg eax
+		{"neg sets CF", "b805000000f7d8", FlagCF | FlagAF | FlagSF},
+		// mov eax, 0; neg eax
+		{"neg of zero", "b800000000f7d8", FlagPF | FlagZF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, flagNames(uint64(tt.flags)), flagNames(cpu.GetFlags()))
+		})
+	}
+}
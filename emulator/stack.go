@@ -0,0 +1,30 @@
+package emulator
+
+import "fmt"
+
+// StackRange returns the bounds [lo, hi) of the stack region.
+func (cpu *CPU) StackRange() (lo, hi uint64) {
+	return StackTop - cpu.stackSize, StackTop
+}
+
+func (cpu *CPU) push(v uint64, size int) error {
+	lo, hi := cpu.StackRange()
+	rsp := uint64(cpu.registers[RSP]) - uint64(size)
+	if rsp < lo || rsp+uint64(size) > hi {
+		return &EmulatorError{PC: cpu.pc, Kind: KindStackOverflow, Message: fmt.Sprintf("stack overflow (RSP=0x%x)", rsp)}
+	}
+	cpu.writeMemory(rsp, size, v)
+	cpu.registers[RSP] = int64(rsp)
+	return nil
+}
+
+func (cpu *CPU) pop(size int) (uint64, error) {
+	lo, hi := cpu.StackRange()
+	rsp := uint64(cpu.registers[RSP])
+	if rsp < lo || rsp+uint64(size) > hi {
+		return 0, &EmulatorError{PC: cpu.pc, Kind: KindStackUnderflow, Message: fmt.Sprintf("stack underflow (RSP=0x%x)", rsp)}
+	}
+	v := cpu.readMemory(rsp, size)
+	cpu.registers[RSP] = int64(rsp + uint64(size))
+	return v, nil
+}
@@ -0,0 +1,58 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStack(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		reg  Register
+		want int64
+	}{
+		// mov rax, 0x2a; push rax; pop rbx
+		{"push and pop", "48c7c02a000000505b", RBX, 42},
+		// push -5 (imm8); pop rcx
+		{"push imm8 sign-extends", "6afb59", RCX, -5},
+		// push 0x12345678; pop rdx
+		{"push imm32", "68785634125a", RDX, 0x12345678},
+		// mov r9, 3; push r9; pop r10
+		{"REX.B push and pop", "49c7c1030000004151415a", R10, 3},
+		// call f; jmp end; f: mov rax, 7; ret
+		{"call and ret", "e802000000eb0848c7c007000000c3", RAX, 7},
+		// call f; f: pop rax (the return address is the end of the call)
+		{"call pushes the return address", "e80000000058", RAX, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := runHex(t, tt.hex)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, cpu.GetRegister(tt.reg))
+			require.Equal(t, int64(StackTop), cpu.GetRegister(RSP))
+		})
+	}
+}
+
+func TestStackErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		kind ErrorKind
+	}{
+		// pop rax
+		{"pop on an empty stack", "58", KindStackUnderflow},
+		// ret
+		{"ret on an empty stack", "c3", KindStackUnderflow},
+		// mov ecx, 0x201; l: push rax; loop l
+		{"push past the stack size", "b90102000050e2fd", KindStackOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runHex(t, tt.hex)
+			require.True(t, IsKind(err, tt.kind), "got %v", err)
+		})
+	}
+}
@@ -1,8 +1,14 @@
 package genhex
 
+import "backend/levels"
+
+const rax = 0
+
+// encMovRegImm emits mov r64, imm32 (REX.W C7 /0). The shorter B8+r form is
+// mov r32, imm32, which zero-extends and would turn negative values positive.
 func encMovRegImm(reg int, imm int32) []byte {
-	op := byte(0xB8 + reg)
-	out := []byte{op}
+	modrm := byte(0xC0 | reg)
+	out := []byte{0x48, 0xC7, modrm}
 	out = append(out, u32Bytes(imm)...)
 	return out
 }
@@ -12,20 +18,54 @@ func encMovRegReg(dst, src int) []byte {
 	return []byte{0x48, 0x89, modrm}
 }
 
-func encAddRegImm(reg int, imm int32) []byte {
+// encAddRegImm and encSubRegImm pick the shortest encoding the level allows,
+// as an assembler would: the sign-extended imm8 group 0x83 when the value
+// fits, the RAX-only accumulator form 0x05/0x2D, and the generic 0x81 imm32
+// group otherwise.
+func encAddRegImm(spec levels.Level, reg int, imm int32) []byte {
+	switch {
+	case spec.AllowsImmediate(levels.Imm8) && fitsInt8(imm):
+		return encAddRegImm8(reg, int8(imm))
+	case spec.AllowsImmediate(levels.Acc) && reg == rax:
+		return append([]byte{0x48, 0x05}, u32Bytes(imm)...)
+	}
+	return encAddRegImm32(reg, imm)
+}
+
+func encSubRegImm(spec levels.Level, reg int, imm int32) []byte {
+	switch {
+	case spec.AllowsImmediate(levels.Imm8) && fitsInt8(imm):
+		return encSubRegImm8(reg, int8(imm))
+	case spec.AllowsImmediate(levels.Acc) && reg == rax:
+		return append([]byte{0x48, 0x2D}, u32Bytes(imm)...)
+	}
+	return encSubRegImm32(reg, imm)
+}
+
+func encAddRegImm32(reg int, imm int32) []byte {
 	modrm := byte(0xC0 | reg)
 	out := []byte{0x48, 0x81, modrm}
 	out = append(out, u32Bytes(imm)...)
 	return out
 }
 
-func encSubRegImm(reg int, imm int32) []byte {
+func encSubRegImm32(reg int, imm int32) []byte {
 	modrm := byte(0xC0 | (5 << 3) | reg)
 	out := []byte{0x48, 0x81, modrm}
 	out = append(out, u32Bytes(imm)...)
 	return out
 }
 
+func encAddRegImm8(reg int, imm int8) []byte {
+	modrm := byte(0xC0 | reg)
+	return []byte{0x48, 0x83, modrm, byte(imm)}
+}
+
+func encSubRegImm8(reg int, imm int8) []byte {
+	modrm := byte(0xC0 | (5 << 3) | reg)
+	return []byte{0x48, 0x83, modrm, byte(imm)}
+}
+
 func encAddRegReg(dst, src int) []byte {
 	modrm := byte(0xC0 | (src << 3) | dst)
 	return []byte{0x48, 0x01, modrm}
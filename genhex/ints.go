@@ -5,13 +5,6 @@ import (
 	rand2 "math/rand"
 )
 
-var regMap = map[string]int{
-	"rax": 0,
-	"rbx": 3,
-	"rcx": 1,
-	"rdx": 2,
-}
-
 func u32Bytes(v int32) []byte {
 	b := make([]byte, 4)
 	binary.LittleEndian.PutUint32(b, uint32(v))
@@ -24,6 +17,10 @@ func u64Bytes(v int64) []byte {
 	return b
 }
 
+func fitsInt8(v int32) bool {
+	return v >= -128 && v <= 127
+}
+
 func randInt8(rnd *rand2.Rand) int8 {
 	return int8(rnd.Intn(0x100) - 0x80)
 }
@@ -1,14 +1,11 @@
 package genhex
 
-import rand2 "math/rand"
+import (
+	"fmt"
+	rand2 "math/rand"
+	"slices"
 
-const (
-	min8  = -128
-	max8  = 127
-	min16 = -32768
-	max16 = 32767
-	min32 = -2147483648
-	max32 = 2147483647
+	"backend/levels"
 )
 
 func clampInt(v, lo, hi int) int {
@@ -55,123 +52,228 @@ func randInt64InRange(rnd *rand2.Rand, lo, hi int64) int64 {
 	return lo + rnd.Int63n(width)
 }
 
-func applyInitAddSub(out *[]byte, rnd *rand2.Rand, reg int, init int64, bitMin, bitMax int64, cast func(int64) int32) {
-	if rnd.Intn(2) == 0 {
-		allowedMin := clampInt64(bitMin-init, bitMin, bitMax)
-		allowedMax := clampInt64(bitMax-init, bitMin, bitMax)
-		imm := randInt64InRange(rnd, allowedMin, allowedMax)
-		*out = append(*out, encAddRegImm(reg, cast(imm))...)
-	} else {
-		allowedMin := clampInt64(init-bitMax, bitMin, bitMax)
-		allowedMax := clampInt64(init-bitMin, bitMin, bitMax)
-		imm := randInt64InRange(rnd, allowedMin, allowedMax)
-		*out = append(*out, encSubRegImm(reg, cast(imm))...)
+// choose returns a random index below n. A single choice consumes no
+// randomness, so fixed parts of a level do not shift the sequence.
+func choose(rnd *rand2.Rand, n int) int {
+	if n == 1 {
+		return 0
 	}
+	return rnd.Intn(n)
 }
 
-func appendAddImm32(out *[]byte, rnd *rand2.Rand, dst int, regVals map[int]int64) {
-	allowedMin := clampInt64(min32-regVals[dst], min32, max32)
-	allowedMax := clampInt64(max32-regVals[dst], min32, max32)
-	imm64 := randInt64InRange(rnd, allowedMin, allowedMax)
-	*out = append(*out, encAddRegImm(dst, int32(imm64))...)
-	regVals[dst] += imm64
+func randValue(rnd *rand2.Rand, bits int) int64 {
+	switch bits {
+	case 8:
+		return int64(randInt8(rnd))
+	case 16:
+		return int64(randInt16(rnd))
+	}
+	return int64(randInt32(rnd))
 }
 
-func appendSubImm32(out *[]byte, rnd *rand2.Rand, dst int, regVals map[int]int64) {
-	allowedMin := clampInt64(regVals[dst]-max32, min32, max32)
-	allowedMax := clampInt64(regVals[dst]-min32, min32, max32)
-	imm64 := randInt64InRange(rnd, allowedMin, allowedMax)
-	*out = append(*out, encSubRegImm(dst, int32(imm64))...)
-	regVals[dst] -= imm64
+// genInst is a generated instruction with the registers it writes and
+// reads. src is -1 for an immediate source.
+type genInst struct {
+	code []byte
+	mov  bool
+	sub  bool
+	dst  int
+	src  int
 }
 
-func genLevel1(rnd *rand2.Rand) []byte {
-	var out []byte
-	val := randInt8(rnd)
-	out = append(out, encMovRegImm(regMap["rax"], int32(val))...)
+// Bounds of the lean passed to generate. Below 0 a draft is made easier:
+// fewest ops, immediate sources only and non-negative immediates, and at
+// minLean also non-negative values and immediates that never carry or
+// borrow a hex digit. Above 0 it is made harder: most ops, register sources
+// and negative immediates, and at maxLean also initial values of at least
+// half the range.
+const (
+	minLean = -2
+	maxLean = 2
+)
 
-	applyInitAddSub(&out, rnd, regMap["rax"], int64(val), min8, max8, func(i int64) int32 { return int32(int8(i)) })
+// generate builds a puzzle for spec: a mov for every register, then
+// spec.Ops add/sub instructions whose results stay within the value range.
+// The operands are planned backwards from RAX so that the answer depends on
+// every add/sub; the movs of registers that never reach RAX are dropped.
+// A lean of 0 draws everything uniformly; see minLean for the others.
+func generate(spec levels.Level, rnd *rand2.Rand, lean int) ([]byte, error) {
+	var out []genInst
+	regs := make([]int, len(spec.Registers))
+	regVals := make(map[int]int64)
+	for i, name := range spec.Registers {
+		reg, _ := levels.RegisterNumber(name)
+		var v int64
+		switch {
+		case lean <= minLean:
+			v = randInt64InRange(rnd, 0, spec.MaxValue())
+		case lean >= maxLean:
+			v = randInt64InRange(rnd, spec.MaxValue()/2, spec.MaxValue())
+			if rnd.Intn(2) == 0 {
+				v = -v - 1
+			}
+		default:
+			v = randValue(rnd, spec.ValueBits)
+		}
+		regs[i] = reg
+		regVals[reg] = v
+		out = append(out, genInst{code: encMovRegImm(reg, int32(v)), mov: true, dst: reg, src: -1})
+	}
 
-	return out
-}
+	ops := spec.Ops.Min + choose(rnd, spec.Ops.GenMax()-spec.Ops.Min+1)
+	if lean < 0 {
+		ops = spec.Ops.Min
+	} else if lean > 0 {
+		ops = spec.Ops.GenMax()
+	}
+	plan := planOps(spec, regs, ops, rnd, lean)
+	for _, p := range plan {
+		dst := p.dst
+
+		if p.src >= 0 {
+			var adds []bool
+			if spec.Allows(levels.AddReg) && spec.InRange(regVals[dst]+regVals[p.src]) {
+				adds = append(adds, true)
+			}
+			// sub r, r of one register would cut off what came before
+			if spec.Allows(levels.SubReg) && p.src != dst && spec.InRange(regVals[dst]-regVals[p.src]) {
+				adds = append(adds, false)
+			}
 
-func genLevel2(rnd *rand2.Rand) []byte {
-	var out []byte
-	v := randInt16(rnd)
-	out = append(out, encMovRegImm(regMap["rax"], int32(v))...)
+			if len(adds) > 0 {
+				if adds[choose(rnd, len(adds))] {
+					out = append(out, genInst{code: encAddRegReg(dst, p.src), dst: dst, src: p.src})
+					regVals[dst] += regVals[p.src]
+				} else {
+					out = append(out, genInst{code: encSubRegReg(dst, p.src), sub: true, dst: dst, src: p.src})
+					regVals[dst] -= regVals[p.src]
+				}
+				continue
+			}
+			if !spec.Allows(levels.AddImm) && !spec.Allows(levels.SubImm) {
+				return nil, fmt.Errorf("level %d: no register operation keeps the result in range", spec.Level)
+			}
+		}
 
-	applyInitAddSub(&out, rnd, regMap["rax"], int64(v), min16, max16, func(i int64) int32 { return int32(int16(i)) })
-	return out
-}
+		lo, hi := spec.MinValue(), spec.MaxValue()
+		if spec.Allows(levels.AddImm) && (!spec.Allows(levels.SubImm) || rnd.Intn(2) == 0) {
+			imm := leanImm(spec, rnd, lean, regVals[dst], false, clampInt64(lo-regVals[dst], lo, hi), clampInt64(hi-regVals[dst], lo, hi))
+			out = append(out, genInst{code: encAddRegImm(spec, dst, int32(imm)), dst: dst, src: -1})
+			regVals[dst] += imm
+		} else {
+			imm := leanImm(spec, rnd, lean, regVals[dst], true, clampInt64(regVals[dst]-hi, lo, hi), clampInt64(regVals[dst]-lo, lo, hi))
+			out = append(out, genInst{code: encSubRegImm(spec, dst, int32(imm)), dst: dst, src: -1})
+			regVals[dst] -= imm
+		}
+	}
 
-func genLevel3(rnd *rand2.Rand) []byte {
-	var out []byte
-	v := randInt32(rnd)
-	out = append(out, encMovRegImm(regMap["rax"], int32(v))...)
+	var code []byte
+	for _, in := range pruneDead(out) {
+		code = append(code, in.code...)
+	}
+	return code, nil
+}
 
-	applyInitAddSub(&out, rnd, regMap["rax"], int64(v), min32, max32, func(i int64) int32 { return int32(i) })
-	return out
+// opPlan is the destination and register source (-1 for an immediate) of
+// one add/sub.
+type opPlan struct {
+	dst int
+	src int
 }
 
-func genLevel4(rnd *rand2.Rand) []byte {
-	var out []byte
-	regs := []string{"rax", "rbx", "rcx", "rdx"}
+// planOps picks the operands of ops add/sub, last first. Each destination
+// is a register the answer still depends on at that point and a register
+// source joins that set, so no op is dead. A register op whose values end
+// up out of range falls back to an immediate on the same destination,
+// which keeps it live.
+func planOps(spec levels.Level, regs []int, ops int, rnd *rand2.Rand, lean int) []opPlan {
+	hasImm := spec.Allows(levels.AddImm) || spec.Allows(levels.SubImm)
+	hasReg := spec.Allows(levels.AddReg) || spec.Allows(levels.SubReg)
 
-	regVals := make(map[int]int64)
-	for _, r := range regs {
-		v := randInt32(rnd)
-		reg := regMap[r]
-		regVals[reg] = int64(v)
-		out = append(out, encMovRegImm(reg, int32(v))...)
-	}
-
-	ops := 2 + rnd.Intn(2)
-	for i := 0; i < ops; i++ {
-		dstName := regs[rnd.Intn(len(regs))]
-		dst := regMap[dstName]
-
-		if rnd.Intn(2) == 0 {
-			// try register ops first
-			type cand struct {
-				op  int
-				src int
+	plan := make([]opPlan, ops)
+	live := []int{rax}
+	for i := ops - 1; i >= 0; i-- {
+		p := opPlan{dst: live[choose(rnd, len(live))], src: -1}
+		useReg := hasReg && !hasImm
+		if hasReg && hasImm {
+			switch {
+			case lean < 0:
+			case lean > 0:
+				useReg = true
+			default:
+				useReg = rnd.Intn(2) == 0
 			}
-			var cands []cand
-			for _, srcName := range regs {
-				src := regMap[srcName]
-				resAdd := regVals[dst] + regVals[src]
-				if resAdd >= min32 && resAdd <= max32 {
-					cands = append(cands, cand{0, src})
-				}
-				resSub := regVals[dst] - regVals[src]
-				if resSub >= min32 && resSub <= max32 {
-					cands = append(cands, cand{1, src})
-				}
+		}
+		if useReg {
+			p.src = regs[choose(rnd, len(regs))]
+			if !slices.Contains(live, p.src) {
+				live = append(live, p.src)
 			}
+		}
+		plan[i] = p
+	}
+	return plan
+}
 
-			if len(cands) > 0 {
-				choice := cands[rnd.Intn(len(cands))]
-				if choice.op == 0 {
-					out = append(out, encAddRegReg(dst, choice.src)...)
-					regVals[dst] = regVals[dst] + regVals[choice.src]
-				} else {
-					out = append(out, encSubRegReg(dst, choice.src)...)
-					regVals[dst] = regVals[dst] - regVals[choice.src]
-				}
-			} else {
-				if rnd.Intn(2) == 0 {
-					appendAddImm32(&out, rnd, dst, regVals)
-				} else {
-					appendSubImm32(&out, rnd, dst, regVals)
-				}
-			}
-		} else {
-			if rnd.Intn(2) == 0 {
-				appendAddImm32(&out, rnd, dst, regVals)
-			} else {
-				appendSubImm32(&out, rnd, dst, regVals)
+// leanImm draws the immediate of an add (or sub) of v from lo..hi, the
+// range that keeps the result in range, narrowed according to lean.
+func leanImm(spec levels.Level, rnd *rand2.Rand, lean int, v int64, sub bool, lo, hi int64) int64 {
+	switch {
+	case lean <= minLean && v >= 0:
+		return noCarryImm(rnd, v, sub, spec.ValueBits)
+	case lean < 0 && max(lo, 0) <= hi:
+		lo = max(lo, 0)
+	case lean > 0 && lo <= min(hi, -1):
+		hi = min(hi, -1)
+	}
+	return randInt64InRange(rnd, lo, hi)
+}
+
+// noCarryImm draws a non-negative immediate whose add to (or sub from) the
+// non-negative v carries or borrows no hex digit, so the result stays
+// non-negative and within bits.
+func noCarryImm(rnd *rand2.Rand, v int64, sub bool, bits int) int64 {
+	var imm int64
+	for i := 0; i < bits/4; i++ {
+		digit := int(v >> (4 * i) & 0xF)
+		limit := digit
+		if !sub {
+			limit = 15 - digit
+			if i == bits/4-1 {
+				limit = 7 - digit
 			}
 		}
+		imm |= int64(randInRange(rnd, 0, limit)) << (4 * i)
+	}
+	return imm
+}
+
+// pruneDead removes the instructions whose result never reaches RAX, found
+// by walking backwards from the end with the set of live registers.
+func pruneDead(insts []genInst) []genInst {
+	live := map[int]bool{rax: true}
+	keep := make([]bool, len(insts))
+	for i := len(insts) - 1; i >= 0; i-- {
+		in := insts[i]
+		if !live[in.dst] {
+			continue
+		}
+		keep[i] = true
+		// mov and sub r, r overwrite the old value without reading it
+		zero := in.sub && in.src == in.dst
+		if in.mov || zero {
+			live[in.dst] = false
+		}
+		if in.src >= 0 && !zero {
+			live[in.src] = true
+		}
+	}
+
+	var kept []genInst
+	for i, in := range insts {
+		if keep[i] {
+			kept = append(kept, in)
+		}
 	}
-	return out
+	return kept
 }
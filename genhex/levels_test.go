@@ -0,0 +1,27 @@
+package genhex
+
+import (
+	rand2 "math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"backend/checker"
+	"backend/levels"
+)
+
+// Drafts straight from generate, before any verification, keep all their
+// add/sub after dead code is pruned.
+func TestGenerateKeepsOpCount(t *testing.T) {
+	for _, spec := range levels.Builtin() {
+		rnd := rand2.New(rand2.NewSource(1))
+		for i := 0; i < 500; i++ {
+			code, err := generate(spec, rnd, 0)
+			require.NoError(t, err)
+			report, err := checker.Analyze(code)
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, report.Ops, spec.Ops.Min, "level %d: %x", spec.Level, code)
+			require.LessOrEqual(t, report.Ops, spec.Ops.GenMax(), "level %d: %x", spec.Level, code)
+		}
+	}
+}
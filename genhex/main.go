@@ -6,28 +6,39 @@ import (
 	"fmt"
 	rand2 "math/rand"
 	"time"
+
+	"backend/levels"
 )
 
+// MaxSeed bounds the seeds RandomSeed hands out, keeping them short enough
+// to share and exactly representable as JavaScript numbers.
+const MaxSeed = 1<<31 - 1
+
+// RandomSeed returns a fresh seed in [0, MaxSeed] taken from the clock.
+func RandomSeed() int64 {
+	return rand2.New(rand2.NewSource(time.Now().UnixNano())).Int63n(MaxSeed + 1)
+}
+
+// GenerateHex generates a puzzle for level from a random seed. Callers that
+// need to rebuild the puzzle pick a seed and use GenerateHexSeed.
 func GenerateHex(level int) (spaceHex string, noSpaceHex string, err error) {
-	rnd := rand2.New(rand2.NewSource(time.Now().UnixNano()))
-
-	var bytesOut []byte
-	switch level {
-	case 1:
-		bytesOut = genLevel1(rnd)
-	case 2:
-		bytesOut = genLevel2(rnd)
-	case 3:
-		bytesOut = genLevel3(rnd)
-	case 4:
-		bytesOut = genLevel4(rnd)
-	default:
+	return GenerateHexSeed(level, RandomSeed())
+}
+
+// GenerateHexSeed generates the puzzle for a built-in level and seed with
+// the default rules. The same level and seed always produce the same bytes.
+func GenerateHexSeed(level int, seed int64) (spaceHex string, noSpaceHex string, err error) {
+	spec, ok := levels.Get(level)
+	if !ok {
 		return "", "", errors.New("unsupported level")
 	}
+	return NewGenerator(levels.Builtin(), DefaultRules()).Generate(spec, seed)
+}
 
+func formatHex(code []byte) (spaceHex string, noSpaceHex string) {
 	var buf bytes.Buffer
 	var noSpaceBuf bytes.Buffer
-	for i, b := range bytesOut {
+	for i, b := range code {
 		if i > 0 {
 			buf.WriteRune(' ')
 		}
@@ -35,5 +46,5 @@ func GenerateHex(level int) (spaceHex string, noSpaceHex string, err error) {
 		fmt.Fprintf(&noSpaceBuf, "%02x", b)
 	}
 
-	return buf.String(), noSpaceBuf.String(), nil
+	return buf.String(), noSpaceBuf.String()
 }
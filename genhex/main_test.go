@@ -0,0 +1,38 @@
+package genhex
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateHexSeedIsDeterministic(t *testing.T) {
+	for level := 1; level <= 4; level++ {
+		seen := map[string]bool{}
+		for _, seed := range []int64{0, 1, 42, 12345, MaxSeed} {
+			space, noSpace, err := GenerateHexSeed(level, seed)
+			require.NoError(t, err)
+			require.Equal(t, noSpace, strings.ReplaceAll(space, " ", ""))
+
+			again, _, err := GenerateHexSeed(level, seed)
+			require.NoError(t, err)
+			require.Equal(t, space, again, "level %d seed %d", level, seed)
+			seen[noSpace] = true
+		}
+		require.Greater(t, len(seen), 1, "level %d ignores the seed", level)
+	}
+}
+
+func TestGenerateHexSeedRejectsUnknownLevel(t *testing.T) {
+	_, _, err := GenerateHexSeed(0, 1)
+	require.Error(t, err)
+}
+
+func TestRandomSeedRange(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		seed := RandomSeed()
+		require.GreaterOrEqual(t, seed, int64(0))
+		require.LessOrEqual(t, seed, int64(MaxSeed))
+	}
+}
@@ -0,0 +1,256 @@
+package genhex
+
+import (
+	"errors"
+	"fmt"
+	rand2 "math/rand"
+	"sort"
+	"strings"
+
+	"backend/checker"
+	"backend/levels"
+)
+
+// Names of the verification rules, used in Rules strings, VerifyError and
+// Stats.
+const (
+	RuleLevel      = "level"
+	RuleLive       = "live"
+	RuleNonTrivial = "nontrivial"
+	RuleDifficulty = "difficulty"
+)
+
+// DefaultMaxAttempts bounds how many puzzles Generate draws for one seed.
+const DefaultMaxAttempts = 1000
+
+// Rules selects the quality checks a generated puzzle must pass. Whatever
+// the rules, a puzzle must also be classified as the level it was made for,
+// which rules out overflow: the checker runs under the strict policy and
+// checks every result against the level's range.
+type Rules struct {
+	// Live requires every instruction to change its destination and the
+	// answer to depend on it.
+	Live bool
+	// NonTrivial rejects an answer of 0 or one equal to an immediate.
+	NonTrivial bool
+	// MinDifficulty and MaxDifficulty bound the checker's difficulty score;
+	// 0 leaves that side open.
+	MinDifficulty int
+	MaxDifficulty int
+	// Weights score the difficulty; the zero value means
+	// checker.DefaultWeights.
+	Weights checker.Weights
+	// MaxAttempts bounds regeneration; 0 means DefaultMaxAttempts.
+	MaxAttempts int
+}
+
+func (r Rules) band() string {
+	text := ".."
+	if r.MinDifficulty > 0 {
+		text = fmt.Sprint(r.MinDifficulty) + text
+	}
+	if r.MaxDifficulty > 0 {
+		text += fmt.Sprint(r.MaxDifficulty)
+	}
+	return text
+}
+
+func (r Rules) weights() checker.Weights {
+	if r.Weights == (checker.Weights{}) {
+		return checker.DefaultWeights()
+	}
+	return r.Weights
+}
+
+func DefaultRules() Rules {
+	return Rules{Live: true, NonTrivial: true}
+}
+
+// ParseRules reads a comma-separated list of rule names. "all" enables every
+// rule and an empty string or "none" disables them.
+func ParseRules(s string) (Rules, error) {
+	var r Rules
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "none":
+		case "all":
+			r = DefaultRules()
+		case RuleLive:
+			r.Live = true
+		case RuleNonTrivial:
+			r.NonTrivial = true
+		default:
+			return Rules{}, fmt.Errorf("unknown rule %q (want %s or %s)", name, RuleLive, RuleNonTrivial)
+		}
+	}
+	return r, nil
+}
+
+// VerifyError says which rule a puzzle broke.
+type VerifyError struct {
+	Rule   string
+	Offset int
+	Msg    string
+}
+
+func (e *VerifyError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("%s: %s", e.Rule, e.Msg)
+	}
+	return fmt.Sprintf("%s: %04x: %s", e.Rule, e.Offset, e.Msg)
+}
+
+// Verify checks that code passes rules and that the checker, trying the
+// levels of ls in order, classifies it as spec. Failures are *VerifyError.
+func Verify(code []byte, spec levels.Level, ls []levels.Level, rules Rules) error {
+	_, err := verify(code, spec, ls, rules)
+	return err
+}
+
+// verify is Verify that also returns the difficulty score, or 0 when the
+// puzzle failed before it was scored.
+func verify(code []byte, spec levels.Level, ls []levels.Level, rules Rules) (int, error) {
+	report, err := checker.Analyze(code)
+	if err != nil {
+		return 0, &VerifyError{Rule: RuleLevel, Offset: -1, Msg: err.Error()}
+	}
+
+	report.Classify(ls)
+	if report.Level != spec.Level {
+		msg := fmt.Sprintf("classified as level %d", report.Level)
+		for _, r := range report.Rejected {
+			if r.Level == spec.Level {
+				msg = r.Reason
+			}
+		}
+		return 0, &VerifyError{Rule: RuleLevel, Offset: -1, Msg: msg}
+	}
+
+	if rules.Live {
+		if err := verifyLive(report); err != nil {
+			return 0, err
+		}
+	}
+
+	if rules.NonTrivial {
+		if report.Result == 0 {
+			return 0, &VerifyError{Rule: RuleNonTrivial, Offset: -1, Msg: "the answer is 0"}
+		}
+		for _, step := range report.Steps {
+			if step.Src == "" && step.Imm == report.Result {
+				return 0, &VerifyError{Rule: RuleNonTrivial, Offset: step.Offset, Msg: fmt.Sprintf("the answer equals the immediate of %s", step.Intel)}
+			}
+		}
+	}
+
+	score := report.Difficulty().Weighted(rules.weights())
+	if (rules.MinDifficulty > 0 && score < rules.MinDifficulty) || (rules.MaxDifficulty > 0 && score > rules.MaxDifficulty) {
+		return score, &VerifyError{Rule: RuleDifficulty, Offset: -1, Msg: fmt.Sprintf("difficulty %d is outside %s", score, rules.band())}
+	}
+	return score, nil
+}
+
+// verifyLive rejects instructions that leave their destination unchanged
+// or whose result never reaches RAX.
+func verifyLive(report *checker.Report) error {
+	values := map[string]int64{}
+	for _, step := range report.Steps {
+		if step.IsOp() && step.Result == values[step.Dst] {
+			return &VerifyError{Rule: RuleLive, Offset: step.Offset, Msg: fmt.Sprintf("%s does not change %s", step.Intel, step.Dst)}
+		}
+		values[step.Dst] = step.Result
+	}
+
+	live := map[string]bool{"rax": true}
+	for i := len(report.Steps) - 1; i >= 0; i-- {
+		step := report.Steps[i]
+		if !live[step.Dst] {
+			return &VerifyError{Rule: RuleLive, Offset: step.Offset, Msg: fmt.Sprintf("the answer does not depend on %s", step.Intel)}
+		}
+		if step.Overwrites() {
+			live[step.Dst] = false
+		}
+		if src := step.Reads(); src != "" {
+			live[src] = true
+		}
+	}
+	return nil
+}
+
+// Stats counts the puzzles a Generator produced and the drafts it threw
+// away, by rule.
+type Stats struct {
+	Puzzles  int
+	Attempts int
+	Rejected map[string]int
+}
+
+func (s Stats) String() string {
+	text := fmt.Sprintf("%d puzzles in %d attempts", s.Puzzles, s.Attempts)
+	if len(s.Rejected) == 0 {
+		return text
+	}
+	rules := make([]string, 0, len(s.Rejected))
+	for rule := range s.Rejected {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for i, rule := range rules {
+		rules[i] = fmt.Sprintf("%s %d", rule, s.Rejected[rule])
+	}
+	return text + ", rejected: " + strings.Join(rules, ", ")
+}
+
+// Generator draws puzzles for a level set until one passes its Rules,
+// keeping Stats across calls.
+type Generator struct {
+	Levels []levels.Level
+	Rules  Rules
+	Stats  Stats
+}
+
+func NewGenerator(ls []levels.Level, rules Rules) *Generator {
+	return &Generator{Levels: ls, Rules: rules, Stats: Stats{Rejected: map[string]int{}}}
+}
+
+// Generate returns the first puzzle for spec drawn from seed that passes
+// verification. Every draw continues the same random sequence, so a seed
+// still always gives the same puzzle. A draw scored outside the difficulty
+// band makes the next ones lean easier or harder.
+func (g *Generator) Generate(spec levels.Level, seed int64) (spaceHex string, noSpaceHex string, err error) {
+	rnd := rand2.New(rand2.NewSource(seed))
+	attempts := g.Rules.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+
+	var last error
+	lean := 0
+	for i := 0; i < attempts; i++ {
+		g.Stats.Attempts++
+		code, err := generate(spec, rnd, lean)
+		if err != nil {
+			return "", "", err
+		}
+		score, err := verify(code, spec, g.Levels, g.Rules)
+		if err == nil {
+			g.Stats.Puzzles++
+			spaceHex, noSpaceHex = formatHex(code)
+			return spaceHex, noSpaceHex, nil
+		}
+		var verr *VerifyError
+		if !errors.As(err, &verr) {
+			return "", "", err
+		}
+		g.Stats.Rejected[verr.Rule]++
+		if verr.Rule == RuleDifficulty {
+			if g.Rules.MaxDifficulty > 0 && score > g.Rules.MaxDifficulty {
+				lean = max(lean-1, minLean)
+			} else {
+				lean = min(lean+1, maxLean)
+			}
+		}
+		last = err
+	}
+	return "", "", fmt.Errorf("level %d: no puzzle passed verification in %d attempts, last: %w", spec.Level, attempts, last)
+}
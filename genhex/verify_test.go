@@ -0,0 +1,122 @@
+package genhex
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"backend/checker"
+	"backend/emulator"
+	"backend/levels"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rules
+	}{
+		{"", Rules{}},
+		{"none", Rules{}},
+		{"all", DefaultRules()},
+		{"live", Rules{Live: true}},
+		{"live, nontrivial", Rules{Live: true, NonTrivial: true}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRules(tt.in)
+		require.NoError(t, err, tt.in)
+		require.Equal(t, tt.want, got, tt.in)
+	}
+
+	_, err := ParseRules("nooverflow")
+	require.ErrorContains(t, err, `unknown rule "nooverflow"`)
+}
+
+func TestVerifyRules(t *testing.T) {
+	spec, _ := levels.Get(1)
+	tests := []struct {
+		name string
+		hex  string
+		rule string
+	}{
+		// mov rax, 5; add rax, 3
+		{"passes", "48c7c0050000004883c003", ""},
+		// mov rax, 100; add rax, 100
+		{"wrong level", "48c7c0640000004883c064", RuleLevel},
+		// mov rax, 5; add rax, 0
+		{"op that changes nothing", "48c7c0050000004883c000", RuleLive},
+		// mov rbx, 1; mov rax, 5; add rbx, 3
+		{"op the answer ignores", "48c7c30100000048c7c0050000004883c303", RuleLive},
+		// mov rax, 3; sub rax, 3
+		{"zero answer", "48c7c0030000004883e803", RuleNonTrivial},
+		// mov rax, 0; add rax, 3
+		{"answer equals an immediate", "48c7c0000000004883c003", RuleNonTrivial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := mustHex(t, tt.hex)
+			err := Verify(code, spec, levels.Builtin(), DefaultRules())
+			if tt.rule == "" {
+				require.NoError(t, err)
+				return
+			}
+			var verr *VerifyError
+			require.True(t, errors.As(err, &verr), "got %v", err)
+			require.Equal(t, tt.rule, verr.Rule)
+		})
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	code, err := emulator.ParseHexString(s)
+	require.NoError(t, err)
+	return code
+}
+
+func TestGenerateMeetsDifficultyBand(t *testing.T) {
+	tests := []struct {
+		level    int
+		min, max int
+	}{
+		{1, 0, 10},
+		{3, 0, 12},
+		{3, 15, 0},
+		{5, 0, 14},
+		{5, 35, 0},
+	}
+	for _, tt := range tests {
+		spec, ok := levels.Get(tt.level)
+		require.True(t, ok)
+		rules := DefaultRules()
+		rules.MinDifficulty, rules.MaxDifficulty = tt.min, tt.max
+		gen := NewGenerator(levels.Builtin(), rules)
+		for seed := int64(0); seed < 100; seed++ {
+			_, hex, err := gen.Generate(spec, seed)
+			require.NoError(t, err, "level %d seed %d", tt.level, seed)
+
+			report, err := checker.Check(hex, levels.Builtin())
+			require.NoError(t, err)
+			score := report.Difficulty().Score
+			require.GreaterOrEqual(t, score, tt.min, "level %d seed %d", tt.level, seed)
+			if tt.max > 0 {
+				require.LessOrEqual(t, score, tt.max, "level %d seed %d", tt.level, seed)
+			}
+		}
+		// the band steers the draws instead of just filtering them
+		require.Less(t, gen.Stats.Attempts, 5*100, "level %d %s", tt.level, gen.Stats)
+	}
+}
+
+func TestVerifyUsesRuleWeights(t *testing.T) {
+	spec, _ := levels.Get(1)
+	// mov rax, 5; add rax, 3
+	code := []byte{0x48, 0xc7, 0xc0, 0x05, 0, 0, 0, 0x48, 0x83, 0xc0, 0x03}
+
+	rules := DefaultRules()
+	rules.MaxDifficulty = 1
+	require.Error(t, Verify(code, spec, levels.Builtin(), rules))
+
+	rules.Weights = checker.Weights{Carries: 1}
+	require.NoError(t, Verify(code, spec, levels.Builtin(), rules))
+}
@@ -0,0 +1,70 @@
+package levels
+
+import (
+	"fmt"
+	"strings"
+)
+
+var immediateDocs = map[string]string{
+	Imm8:  "`REX.W 83 /0,/5 ib`",
+	Acc:   "`REX.W 05/2D id`（rax のみ）",
+	Imm32: "`REX.W 81 /0,/5 id`",
+}
+
+func opsText(r Range) string {
+	switch {
+	case r.Max == 0:
+		return fmt.Sprintf("%d 回以上", r.Min)
+	case r.Min == r.Max:
+		return fmt.Sprintf("%d 回", r.Min)
+	}
+	return fmt.Sprintf("%d〜%d 回", r.Min, r.Max)
+}
+
+func codeList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = "`" + s + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Markdown renders ls as the level reference in docs/levels.md.
+func Markdown(ls []Level) string {
+	var b strings.Builder
+	b.WriteString("<!-- levels/levels.json から go generate ./levels で生成。直接編集しないこと。 -->\n\n")
+	b.WriteString("# フラッシュ機械語 レベル一覧\n\n")
+	b.WriteString("各レベルでは、まず使用レジスタすべてに `mov r64, imm32`（`48 C7 /0`）で初期値を入れ、")
+	b.WriteString("その後 add/sub を指定回数だけ実行する。初期値・即値・途中結果はすべて値の範囲に収まる。")
+	b.WriteString("答えは RAX の値。判定は番号の小さいレベルから順に行い、最初に条件を満たしたレベルとする。")
+	b.WriteString("レジスタ・命令・即値エンコーディングは生成器が出題に使うもので、判定には影響しない。")
+	b.WriteString("判定は add/sub の回数と値の範囲だけで行い、`mov reg, reg` や 0 初期化の `xor reg, reg` はどのレベルでも使える。\n\n")
+
+	b.WriteString("| Level | 値の範囲 | add/sub 回数 | レジスタ | 命令 | 即値エンコーディング |\n")
+	b.WriteString("|-------|----------|--------------|----------|------|----------------------|\n")
+	for _, l := range ls {
+		fmt.Fprintf(&b, "| %d | int%d | %s | %s | %s | %s |\n",
+			l.Level, l.ValueBits, opsText(l.Ops), codeList(l.Registers), codeList(l.Instructions), codeList(l.Immediates))
+	}
+
+	for _, l := range ls {
+		fmt.Fprintf(&b, "\n## Level %d: %s\n\n", l.Level, l.Title)
+		if l.Description != "" {
+			b.WriteString(l.Description + "\n\n")
+		}
+		fmt.Fprintf(&b, "- 値の範囲：int%d（%d〜%d）\n", l.ValueBits, l.MinValue(), l.MaxValue())
+		fmt.Fprintf(&b, "- add/sub 回数：%s\n", opsText(l.Ops))
+		if gen := l.Ops.GenMax(); gen != l.Ops.Max {
+			fmt.Fprintf(&b, "- 生成する問題の add/sub 回数：%s\n", opsText(Range{Min: l.Ops.Min, Max: gen}))
+		}
+		fmt.Fprintf(&b, "- レジスタ：%s\n", codeList(l.Registers))
+		fmt.Fprintf(&b, "- 命令：%s\n", codeList(l.Instructions))
+		if len(l.Immediates) > 0 {
+			b.WriteString("- 即値エンコーディング：\n")
+			for _, enc := range l.Immediates {
+				fmt.Fprintf(&b, "    - `%s`: %s\n", enc, immediateDocs[enc])
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+// Command gendoc writes the level reference generated from a level set.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"backend/levels"
+)
+
+func main() {
+	out := flag.String("o", "", "output file (default stdout)")
+	spec := flag.String("levels", "", "level set to document instead of the built-in one")
+	flag.Parse()
+
+	ls := levels.Builtin()
+	if *spec != "" {
+		var err error
+		if ls, err = levels.LoadFile(*spec); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	doc := levels.Markdown(ls)
+	if *out == "" {
+		fmt.Print(doc)
+		return
+	}
+	if err := os.WriteFile(*out, []byte(doc), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
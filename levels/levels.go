@@ -0,0 +1,249 @@
+// Package levels holds the puzzle level definitions shared by the generator,
+// the checker and the documentation. The built-in set is read from
+// levels.json; other sets can be loaded with Load or LoadFile.
+package levels
+
+//go:generate go run ./gendoc -o ../docs/levels.md
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Instruction forms a level may allow. The destination is always one of the
+// level's registers; the source is an immediate or another of its registers.
+const (
+	AddImm = "add imm"
+	SubImm = "sub imm"
+	AddReg = "add reg"
+	SubReg = "sub reg"
+)
+
+// Immediate encodings of add/sub with an immediate.
+const (
+	// Imm8 is REX.W 83 /0 or /5 ib, a sign-extended 8-bit immediate.
+	Imm8 = "imm8"
+	// Acc is REX.W 05 or 2D id, the RAX-only accumulator form.
+	Acc = "acc"
+	// Imm32 is REX.W 81 /0 or /5 id.
+	Imm32 = "imm32"
+)
+
+var registerNumbers = map[string]int{
+	"rax": 0,
+	"rcx": 1,
+	"rdx": 2,
+	"rbx": 3,
+	"rsi": 6,
+	"rdi": 7,
+}
+
+// RegisterNumber returns the ModRM number of a register a level may use.
+func RegisterNumber(name string) (int, bool) {
+	n, ok := registerNumbers[name]
+	return n, ok
+}
+
+// Range bounds the number of add/sub instructions in a level.
+type Range struct {
+	Min int `json:"min"`
+	// Max is the most the checker accepts; 0 leaves the range open.
+	Max int `json:"max,omitempty"`
+	// GenerateMax is the most the generator writes. It defaults to Max and
+	// is required when Max is open.
+	GenerateMax int `json:"generateMax,omitempty"`
+}
+
+// Contains reports whether a program with n add/sub instructions fits.
+func (r Range) Contains(n int) bool {
+	return n >= r.Min && (r.Max == 0 || n <= r.Max)
+}
+
+// GenMax is the largest count the generator picks.
+func (r Range) GenMax() int {
+	if r.GenerateMax > 0 {
+		return r.GenerateMax
+	}
+	return r.Max
+}
+
+// Level describes one difficulty level. Every register is loaded with
+// mov r64, imm32 and then Ops add/sub instructions run; the initial values,
+// the immediates and every intermediate result stay within ValueBits.
+// Registers, Instructions and Immediates say what the generator writes, the
+// encodings in the order it tries them. The checker decides a level from
+// Ops and ValueBits alone.
+type Level struct {
+	Level        int      `json:"level"`
+	Title        string   `json:"title"`
+	Description  string   `json:"description"`
+	ValueBits    int      `json:"valueBits"`
+	Registers    []string `json:"registers"`
+	Instructions []string `json:"instructions"`
+	Ops          Range    `json:"ops"`
+	Immediates   []string `json:"immediates"`
+}
+
+func (l Level) MinValue() int64 {
+	return -1 << (l.ValueBits - 1)
+}
+
+func (l Level) MaxValue() int64 {
+	return 1<<(l.ValueBits-1) - 1
+}
+
+func (l Level) InRange(v int64) bool {
+	return v >= l.MinValue() && v <= l.MaxValue()
+}
+
+func (l Level) Allows(instruction string) bool {
+	return contains(l.Instructions, instruction)
+}
+
+func (l Level) AllowsImmediate(encoding string) bool {
+	return contains(l.Immediates, encoding)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (l Level) validate() error {
+	switch l.ValueBits {
+	case 8, 16, 32:
+	default:
+		return fmt.Errorf("valueBits must be 8, 16 or 32, not %d", l.ValueBits)
+	}
+
+	if len(l.Registers) == 0 {
+		return fmt.Errorf("no registers")
+	}
+	seen := map[string]bool{}
+	for _, r := range l.Registers {
+		if _, ok := registerNumbers[r]; !ok {
+			return fmt.Errorf("unknown register %q", r)
+		}
+		if seen[r] {
+			return fmt.Errorf("register %q listed twice", r)
+		}
+		seen[r] = true
+	}
+	if !seen["rax"] {
+		return fmt.Errorf("registers must include rax, which holds the answer")
+	}
+
+	if len(l.Instructions) == 0 {
+		return fmt.Errorf("no instructions")
+	}
+	for _, inst := range l.Instructions {
+		switch inst {
+		case AddImm, SubImm, AddReg, SubReg:
+		default:
+			return fmt.Errorf("unknown instruction %q", inst)
+		}
+	}
+
+	if l.Ops.Min < 1 || l.Ops.Max != 0 && l.Ops.Max < l.Ops.Min {
+		return fmt.Errorf("ops must satisfy 1 <= min <= max, got %d..%d", l.Ops.Min, l.Ops.Max)
+	}
+	if l.Ops.GenMax() < l.Ops.Min || l.Ops.Max != 0 && l.Ops.GenMax() > l.Ops.Max {
+		return fmt.Errorf("ops generateMax %d must lie in %d..%d", l.Ops.GenMax(), l.Ops.Min, l.Ops.Max)
+	}
+
+	for _, enc := range l.Immediates {
+		switch enc {
+		case Imm8, Acc, Imm32:
+		default:
+			return fmt.Errorf("unknown immediate encoding %q", enc)
+		}
+	}
+	if l.Allows(AddImm) || l.Allows(SubImm) {
+		wide := l.AllowsImmediate(Imm32) || (l.AllowsImmediate(Acc) && len(l.Registers) == 1)
+		if !wide && !(l.ValueBits == 8 && l.AllowsImmediate(Imm8)) {
+			return fmt.Errorf("no immediate encoding holds %d-bit values for every register", l.ValueBits)
+		}
+	}
+	return nil
+}
+
+type file struct {
+	Levels []Level `json:"levels"`
+}
+
+// Load reads a JSON level set. Levels must be numbered in ascending order,
+// because the checker reports the first one a program satisfies.
+func Load(r io.Reader) ([]Level, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var f file
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("levels: %w", err)
+	}
+	if len(f.Levels) == 0 {
+		return nil, fmt.Errorf("levels: no levels defined")
+	}
+	for i, l := range f.Levels {
+		if i > 0 && l.Level <= f.Levels[i-1].Level {
+			return nil, fmt.Errorf("levels: level %d must come after level %d", l.Level, f.Levels[i-1].Level)
+		}
+		if err := l.validate(); err != nil {
+			return nil, fmt.Errorf("levels: level %d: %w", l.Level, err)
+		}
+	}
+	return f.Levels, nil
+}
+
+func LoadFile(path string) ([]Level, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ls, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ls, nil
+}
+
+//go:embed levels.json
+var builtinJSON string
+
+var builtin = mustLoad(builtinJSON)
+
+func mustLoad(src string) []Level {
+	ls, err := Load(strings.NewReader(src))
+	if err != nil {
+		panic(err)
+	}
+	return ls
+}
+
+// Builtin returns the level set compiled into the program.
+func Builtin() []Level {
+	return builtin
+}
+
+// Find returns the level numbered n in ls.
+func Find(ls []Level, n int) (Level, bool) {
+	for _, l := range ls {
+		if l.Level == n {
+			return l, true
+		}
+	}
+	return Level{}, false
+}
+
+// Get returns the built-in level numbered n.
+func Get(n int) (Level, bool) {
+	return Find(builtin, n)
+}
@@ -0,0 +1,61 @@
+package levels
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// level is a valid level 1 whose fields the tests override.
+const level = `"level": 1, "valueBits": 8, "registers": ["rax"], "instructions": ["add imm"], "ops": {"min": 1, "max": 1}, "immediates": ["imm8"]`
+
+func TestBuiltinLoads(t *testing.T) {
+	ls := Builtin()
+	require.NotEmpty(t, ls)
+	for i, l := range ls {
+		require.Equal(t, i+1, l.Level)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"not JSON", `{`, "unexpected EOF"},
+		{"unknown field", `{"levels": [{` + level + `, "color": "red"}]}`, `unknown field "color"`},
+		{"no levels", `{"levels": []}`, "no levels defined"},
+		{"out of order", `{"levels": [{` + level + `}, {` + level + `}]}`, "level 1 must come after level 1"},
+		{"value bits", `{"levels": [{` + strings.Replace(level, `"valueBits": 8`, `"valueBits": 64`, 1) + `}]}`, "valueBits must be 8, 16 or 32, not 64"},
+		{"no registers", `{"levels": [{` + strings.Replace(level, `["rax"]`, `[]`, 1) + `}]}`, "no registers"},
+		{"unknown register", `{"levels": [{` + strings.Replace(level, `["rax"]`, `["rax", "r8"]`, 1) + `}]}`, `unknown register "r8"`},
+		{"duplicate register", `{"levels": [{` + strings.Replace(level, `["rax"]`, `["rax", "rax"]`, 1) + `}]}`, `register "rax" listed twice`},
+		{"no rax", `{"levels": [{` + strings.Replace(level, `["rax"]`, `["rbx"]`, 1) + `}]}`, "registers must include rax"},
+		{"no instructions", `{"levels": [{` + strings.Replace(level, `["add imm"]`, `[]`, 1) + `}]}`, "no instructions"},
+		{"unknown instruction", `{"levels": [{` + strings.Replace(level, `["add imm"]`, `["mul imm"]`, 1) + `}]}`, `unknown instruction "mul imm"`},
+		{"ops min", `{"levels": [{` + strings.Replace(level, `"min": 1`, `"min": 0`, 1) + `}]}`, "ops must satisfy 1 <= min <= max, got 0..1"},
+		{"ops max below min", `{"levels": [{` + strings.Replace(level, `"min": 1, "max": 1`, `"min": 3, "max": 2`, 1) + `}]}`, "ops must satisfy 1 <= min <= max, got 3..2"},
+		{"open ops without generateMax", `{"levels": [{` + strings.Replace(level, `"min": 1, "max": 1`, `"min": 2`, 1) + `}]}`, "ops generateMax 0 must lie in 2..0"},
+		{"generateMax above max", `{"levels": [{` + strings.Replace(level, `"max": 1`, `"max": 1, "generateMax": 2`, 1) + `}]}`, "ops generateMax 2 must lie in 1..1"},
+		{"unknown encoding", `{"levels": [{` + strings.Replace(level, `["imm8"]`, `["imm16"]`, 1) + `}]}`, `unknown immediate encoding "imm16"`},
+		{"imm8 too narrow", `{"levels": [{` + strings.Replace(level, `"valueBits": 8`, `"valueBits": 16`, 1) + `}]}`, "no immediate encoding holds 16-bit values"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(tt.json))
+			require.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestOpenOpsRange(t *testing.T) {
+	ls, err := Load(strings.NewReader(`{"levels": [{` + strings.Replace(level, `"min": 1, "max": 1`, `"min": 2, "generateMax": 3`, 1) + `}]}`))
+	require.NoError(t, err)
+	ops := ls[0].Ops
+	require.False(t, ops.Contains(1))
+	require.True(t, ops.Contains(2))
+	require.True(t, ops.Contains(100))
+	require.Equal(t, 3, ops.GenMax())
+}
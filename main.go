@@ -4,46 +4,134 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
+	"backend/assembler"
 	"backend/checker"
 	"backend/emulator"
 	"backend/genhex"
+	"backend/levels"
 )
 
 func main() {
-	cpu := emulator.NewCPU()
+	overflow := flag.String("overflow", "error", "overflow policy: error, wrap or wrap32")
+	maxSteps := flag.Int("max-steps", emulator.DefaultMaxSteps, "maximum number of instructions to execute")
+	stackSize := flag.Int("stack-size", emulator.DefaultStackSize, "stack size in bytes")
+	syntax := flag.String("syntax", "intel", "listing syntax: intel or att")
+	anatomy := flag.Bool("anatomy", false, "break each listed instruction down into its encoding fields")
+	trace := flag.Bool("trace", false, "print every executed instruction with the registers it changed")
+	asmFile := flag.String("asm", "", "assemble and run an Intel-syntax source file")
+	hexCode := flag.String("hex", "", "run this machine code instead of the generated levels")
+	seed := flag.Int64("seed", -1, "generate the levels from this seed instead of a random one")
+	debug := flag.Bool("debug", false, "step through the -asm or -hex program in an interactive debugger")
+	levelFile := flag.String("levels", "", "generate and check puzzles with the level set in this JSON file")
+	verify := flag.String("verify", "all", "puzzle quality rules: all, none or a list of live, nontrivial")
+	difficulty := flag.String("difficulty", "", "generate puzzles whose difficulty score is in min:max (either side may be empty)")
+	flag.Parse()
+
+	policy, err := emulator.ParseOverflowPolicy(*overflow)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *syntax != "intel" && *syntax != "att" {
+		fmt.Fprintf(os.Stderr, "unknown syntax %q (want intel or att)\n", *syntax)
+		os.Exit(2)
+	}
+
+	levelSet := levels.Builtin()
+	if *levelFile != "" {
+		if levelSet, err = levels.LoadFile(*levelFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	rules, err := genhex.ParseRules(*verify)
+	if err == nil {
+		rules.MinDifficulty, rules.MaxDifficulty, err = parseBand(*difficulty)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	opts := printOptions{syntax: *syntax, anatomy: *anatomy, trace: *trace}
+
+	cpu := emulator.NewCPU(emulator.Config{Overflow: policy, MaxSteps: *maxSteps, StackSize: *stackSize})
 	fmt.Printf("Completed cpu initialization\n")
-	fmt.Printf("RAX=%d, RBX=%d, RCX=%d, RDX=%d\n\n",
-		cpu.GetRegister(emulator.RAX),
-		cpu.GetRegister(emulator.RBX),
-		cpu.GetRegister(emulator.RCX),
-		cpu.GetRegister(emulator.RDX),
-	)
+	printRegisters(cpu)
+	fmt.Println()
 
 	debugMode := true
 
-	if debugMode {
-		for i := 1; i < 5; i++ {
-			fmt.Printf("=== Level %d ===\n", i)
-			if err := genAndRunLevel(cpu, i); err != nil {
+	if *debug {
+		code, err := loadCode(*asmFile, *hexCode)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		debugREPL(emulator.NewMachine(cpu, code), os.Stdin)
+	} else if *hexCode != "" {
+		if err := runHex(cpu, *hexCode, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *asmFile != "" {
+		if err := runAsm(cpu, *asmFile, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else if debugMode {
+		gen := genhex.NewGenerator(levelSet, rules)
+		for _, spec := range levelSet {
+			fmt.Printf("=== Level %d ===\n", spec.Level)
+			if err := genAndRunLevel(cpu, gen, spec, *seed, opts); err != nil {
 				fmt.Printf("Error: %v\n", err)
 				return
 			}
-			fmt.Println("======================\n")
+			fmt.Printf("======================\n\n")
 		}
+		fmt.Printf("Verifier: %s\n", gen.Stats)
 	} else {
 		var hexInput string
 		fmt.Print("Enter machine code (hex): ")
 		fmt.Scanln(&hexInput)
 
-		if err := runHex(cpu, hexInput); err != nil {
+		if err := runHex(cpu, hexInput, opts); err != nil {
 			fmt.Printf("Error: %v\n", err)
 			return
 		}
 	}
 }
 
+// parseBand reads a difficulty band written min:max, where an empty side
+// (or an empty band) leaves that side open.
+func parseBand(s string) (lo, hi int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	minText, maxText, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("difficulty band %q must be min:max", s)
+	}
+	if minText != "" {
+		if lo, err = strconv.Atoi(minText); err != nil {
+			return 0, 0, fmt.Errorf("difficulty band %q: %w", s, err)
+		}
+	}
+	if maxText != "" {
+		if hi, err = strconv.Atoi(maxText); err != nil {
+			return 0, 0, fmt.Errorf("difficulty band %q: %w", s, err)
+		}
+	}
+	return lo, hi, nil
+}
+
 func assertEqualInt(expected, actual int) error {
 	if expected != actual {
 		return fmt.Errorf("assert equal failed: expected=%d, actual=%d", expected, actual)
@@ -51,51 +139,180 @@ func assertEqualInt(expected, actual int) error {
 	return nil
 }
 
-func runHex(cpu *emulator.CPU, hex string) error {
+func printRegisters(cpu *emulator.CPU) {
+	for i := 0; i < emulator.NumRegisters; i++ {
+		reg := emulator.Register(i)
+		fmt.Printf("%-3s=%d", reg, cpu.GetRegister(reg))
+		if i%4 == 3 {
+			fmt.Println()
+		} else {
+			fmt.Print("\t")
+		}
+	}
+}
+
+func printMemory(mem *emulator.Memory) {
+	addrs := mem.Addresses()
+	if len(addrs) == 0 {
+		return
+	}
+	fmt.Println("Memory:")
+	for i := 0; i < len(addrs); {
+		row := addrs[i] &^ 0x0F
+		fmt.Printf("  %016x:", row)
+		for _, b := range mem.ReadBytes(row, 16) {
+			fmt.Printf(" %02x", b)
+		}
+		fmt.Println()
+		for i < len(addrs) && addrs[i] < row+16 {
+			i++
+		}
+	}
+}
+
+type printOptions struct {
+	syntax  string
+	anatomy bool
+	trace   bool
+}
+
+func printListing(code []byte, opts printOptions) error {
+	lines, err := emulator.Disassemble(code)
+	fmt.Println("Listing:")
+	for _, line := range lines {
+		text := line.Intel
+		if opts.syntax == "att" {
+			text = line.ATT
+		}
+		fmt.Printf("  %04x: %-24x %s\n", line.Offset, line.Bytes, text)
+		if opts.anatomy {
+			printAnatomy(line)
+		}
+	}
+	return err
+}
+
+func printAnatomy(line emulator.DisasmLine) {
+	for _, f := range line.Anatomy() {
+		fmt.Printf("        %-12x %-6s %s\n", f.Bytes, f.Kind, f.Note)
+		for _, b := range f.Bits {
+			fmt.Printf("          %-8s %-5s %s\n", b.Name, b.Binary(), b.Note)
+		}
+	}
+}
+
+func printTrace(trace []emulator.TraceEntry) {
+	fmt.Println("Trace:")
+	fmt.Printf("  %4s  %-4s  %-20s  %-32s  %s\n", "step", "pc", "bytes", "instruction", "changes")
+	for _, e := range trace {
+		var changes []string
+		for _, reg := range e.ChangedRegisters() {
+			changes = append(changes, fmt.Sprintf("%s: %d -> %d", reg, e.Before.Registers[reg], e.After.Registers[reg]))
+		}
+		if e.Before.RFlags != e.After.RFlags {
+			changes = append(changes, fmt.Sprintf("RFLAGS: 0x%x -> 0x%x", e.Before.RFlags, e.After.RFlags))
+		}
+		for _, a := range e.Memory {
+			if a.Write {
+				changes = append(changes, fmt.Sprintf("[0x%x] = 0x%x", a.Addr, a.Value))
+			}
+		}
+		fmt.Printf("  %4d  %04x  %-20x  %-32s  %s\n", e.Step, e.PC, e.Bytes, e.Intel, strings.Join(changes, ", "))
+	}
+}
+
+func runHex(cpu *emulator.CPU, hex string, opts printOptions) error {
 	code, err := emulator.ParseHexString(hex)
 	if err != nil {
 		return fmt.Errorf("parse hex: %w", err)
 	}
 
-	decoder := emulator.NewDecoder(code)
-	for decoder.HasMore() {
-		inst, err := decoder.DecodeNext()
-		if err != nil {
-			return fmt.Errorf("decode: %w", err)
-		}
-		if err := cpu.Execute(inst); err != nil {
-			return fmt.Errorf("execute: %w", err)
-		}
+	if err := printListing(code, opts); err != nil {
+		return fmt.Errorf("disassemble: %w", err)
+	}
+
+	machine := emulator.NewMachine(cpu, code)
+	machine.SetTrace(opts.trace)
+	err = machine.Run()
+	if opts.trace {
+		printTrace(machine.Trace())
+	}
+	if err != nil {
+		return fmt.Errorf("run: %w", err)
 	}
 
 	fmt.Printf("\n=== Execution Result ===\n")
-	fmt.Printf("RAX=%d, RBX=%d, RCX=%d, RDX=%d\n",
-		cpu.GetRegister(emulator.RAX),
-		cpu.GetRegister(emulator.RBX),
-		cpu.GetRegister(emulator.RCX),
-		cpu.GetRegister(emulator.RDX),
-	)
+	printRegisters(cpu)
+	fmt.Printf("RFLAGS=0x%x (%s)\n", cpu.GetFlags(), cpu.FlagsString())
+	printMemory(cpu.Memory())
 	fmt.Printf("Final result (int32): %d\n", cpu.GetResult())
 	fmt.Printf("Final result (int32): %x\n", int32(cpu.GetResult()))
 	return nil
 }
 
-func genAndRunLevel(cpu *emulator.CPU, level int) error {
-	spaceHex, noSpaceHex, err := genhex.GenerateHex(level)
+// loadCode returns the program to debug: the assembled source file, the
+// hex string, or hex typed at a prompt when neither is given.
+func loadCode(asmFile, hex string) ([]byte, error) {
+	if asmFile != "" {
+		src, err := os.ReadFile(asmFile)
+		if err != nil {
+			return nil, err
+		}
+		code, err := assembler.Assemble(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", asmFile, err)
+		}
+		return code, nil
+	}
+	if hex == "" {
+		fmt.Print("Enter machine code (hex): ")
+		fmt.Scanln(&hex)
+	}
+	return emulator.ParseHexString(hex)
+}
+
+func runAsm(cpu *emulator.CPU, path string, opts printOptions) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	hex, err := assembler.AssembleHex(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Println("Assembled hex: " + hex)
+	return runHex(cpu, hex, opts)
+}
+
+// genAndRunLevel generates a puzzle for spec, checks that the generator's
+// level set classifies it as spec's level and runs it. A negative seed picks
+// a random one.
+func genAndRunLevel(cpu *emulator.CPU, gen *genhex.Generator, spec levels.Level, seed int64, opts printOptions) error {
+	if seed < 0 {
+		seed = genhex.RandomSeed()
+	}
+	spaceHex, noSpaceHex, err := gen.Generate(spec, seed)
 	if err != nil {
 		return fmt.Errorf("GenerateHex: %w", err)
 	}
+	fmt.Printf("Seed: %d\n", seed)
 	fmt.Println("Generated hex: " + spaceHex)
 
-	check, err := checker.CheckLevel(noSpaceHex)
+	report, err := checker.Check(noSpaceHex, gen.Levels)
 	if err != nil {
 		return fmt.Errorf("CheckLevel: %w", err)
 	}
-	fmt.Printf("Checker returned: %d\n", check)
+	fmt.Printf("Checker returned: %d (%d add/sub, values %d..%d)\n", report.Level, report.Ops, report.Min, report.Max)
+	d := report.Difficulty()
+	fmt.Printf("Difficulty: %d (carries %d, sign changes %d, live registers %d, depth %d, negative immediates %d)\n",
+		d.Score, d.Carries, d.SignChanges, d.LiveRegisters, d.Depth, d.NegativeImmediates)
+	for _, r := range report.Rejected {
+		fmt.Printf("  not level %d: %s\n", r.Level, r.Reason)
+	}
 
-	if err := assertEqualInt(level, check); err != nil {
+	if err := assertEqualInt(spec.Level, report.Level); err != nil {
 		return err
 	}
 
-	return runHex(cpu, noSpaceHex)
+	return runHex(cpu, noSpaceHex, opts)
 }
@@ -29,14 +29,15 @@ const runTests = async () => {
         const testCases = [
             { input: "48C7C10A00000048C7C3030000004801D94889C8", expected: "d", desc: "Complex calculation" },
             { input: "4831c0480580f0fa02482dd416c601", expected: "134d9ac", desc: "Complex calculation"},
-            { input: "4831c048c7c30500000048c7c1030000004801d84801c8", expected: "8", desc: "Complex calculation"}
+            { input: "4831c048c7c30500000048c7c1030000004801d84801c8", expected: "8", desc: "Complex calculation"},
+            { input: "48c7c0ffffff7f480501000000", expected: "-80000000", desc: "Wrap at 32 bits", options: { overflow: "wrap32" } }
         ];
 
         let allPassed = true;
 
         for (const [index, test] of testCases.entries()) {
             try {
-                const result = globalThis.RunCode(test.input);
+                const result = globalThis.RunCode(test.input, test.options);
 
                 // エラーチェック
                 if (typeof result === 'object' && result.error) {
@@ -4,8 +4,10 @@
 package main
 
 import (
+	"backend/checker"
 	"backend/emulator"
 	"backend/genhex"
+	"backend/levels"
 	"fmt"
 	"syscall/js"
 )
@@ -13,10 +15,50 @@ import (
 func main() {
 	js.Global().Set("RunCode", js.FuncOf(run))
 	js.Global().Set("GenHex", js.FuncOf(genMachineLanguage))
+	js.Global().Set("Levels", js.FuncOf(levelList))
+	js.Global().Set("Disassemble", js.FuncOf(disassemble))
+	js.Global().Set("MachineLoad", js.FuncOf(machineLoad))
+	js.Global().Set("MachineStep", js.FuncOf(machineStep))
+	js.Global().Set("MachineStepBack", js.FuncOf(machineStepBack))
+	js.Global().Set("MachineSeek", js.FuncOf(machineSeek))
 
 	select {}
 }
 
+// parseOptions reads the optional RunCode/MachineLoad options object.
+func parseOptions(args []js.Value) (cfg emulator.Config, trace bool, err error) {
+	cfg = emulator.DefaultConfig()
+	if len(args) < 2 || args[1].Type() != js.TypeObject {
+		return cfg, false, nil
+	}
+	opts := args[1]
+	if v := opts.Get("overflow"); v.Type() == js.TypeString {
+		policy, err := emulator.ParseOverflowPolicy(v.String())
+		if err != nil {
+			return cfg, false, err
+		}
+		cfg.Overflow = policy
+	}
+	if v := opts.Get("maxSteps"); v.Type() == js.TypeNumber {
+		cfg.MaxSteps = v.Int()
+	}
+	if v := opts.Get("stackSize"); v.Type() == js.TypeNumber {
+		cfg.StackSize = v.Int()
+	}
+	if v := opts.Get("trace"); v.Type() == js.TypeBoolean {
+		trace = v.Bool()
+	}
+	return cfg, trace, nil
+}
+
+func memoryObject(cpu *emulator.CPU) map[string]interface{} {
+	memory := map[string]interface{}{}
+	for _, addr := range cpu.Memory().Addresses() {
+		memory[fmt.Sprintf("%x", addr)] = fmt.Sprintf("%02x", cpu.Memory().ByteAt(addr))
+	}
+	return memory
+}
+
 func run(this js.Value, args []js.Value) interface{} {
 	if len(args) < 1 {
 		return map[string]interface{}{
@@ -26,7 +68,14 @@ func run(this js.Value, args []js.Value) interface{} {
 
 	hexInput := args[0].String()
 
-	cpu := emulator.NewCPU()
+	cfg, trace, err := parseOptions(args)
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
+		}
+	}
+
+	cpu := emulator.NewCPU(cfg)
 	code, err := emulator.ParseHexString(hexInput)
 	if err != nil {
 		return map[string]interface{}{
@@ -34,27 +83,125 @@ func run(this js.Value, args []js.Value) interface{} {
 		}
 	}
 
-	decoder := emulator.NewDecoder(code)
-	for decoder.HasMore() {
-		inst, err := decoder.DecodeNext()
-		if err != nil {
-			return map[string]interface{}{
-				"error": fmt.Sprintf("decode error: %v", err),
-			}
+	m := emulator.NewMachine(cpu, code)
+	m.SetTrace(trace)
+	if err := m.Run(); err != nil {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("execute error: %v", err),
 		}
-
-		if err := cpu.Execute(inst); err != nil {
-			return map[string]interface{}{
-				"error": fmt.Sprintf("execute error: %v", err),
-			}
+		if trace {
+			result["trace"] = traceSteps(m.Trace())
 		}
+		return result
 	}
 
 	//return fmt.Sprintf("%x", int32(cpu.GetResult()))
 
-	return map[string]interface{}{
-		"value": fmt.Sprintf("%x", int32(cpu.GetResult())),
+	result := map[string]interface{}{
+		"value":  fmt.Sprintf("%x", int32(cpu.GetResult())),
+		"flags":  fmt.Sprintf("%x", cpu.GetFlags()),
+		"memory": memoryObject(cpu),
+	}
+	if trace {
+		result["trace"] = traceSteps(m.Trace())
+	}
+	return result
+}
+
+// machine is the program loaded by MachineLoad and driven one instruction
+// at a time by MachineStep, MachineStepBack and MachineSeek.
+var machine *emulator.Machine
+
+func machineState(err error) interface{} {
+	cpu := machine.CPU()
+	state := map[string]interface{}{
+		"step":      machine.Steps(),
+		"done":      machine.Done(),
+		"value":     fmt.Sprintf("%x", int32(cpu.GetResult())),
+		"registers": registerState(cpu.RegisterState()),
+		"memory":    memoryObject(cpu),
+	}
+	if err != nil {
+		state["error"] = err.Error()
+	}
+	return state
+}
+
+func machineLoad(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return map[string]interface{}{"error": "hex string required"}
+	}
+	cfg, _, err := parseOptions(args)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	code, err := emulator.ParseHexString(args[0].String())
+	if err != nil {
+		return map[string]interface{}{"error": fmt.Sprintf("error parsing hex input: %v", err)}
+	}
+	machine = emulator.NewMachine(emulator.NewCPU(cfg), code)
+	return machineState(nil)
+}
+
+func machineStep(this js.Value, args []js.Value) interface{} {
+	if machine == nil {
+		return map[string]interface{}{"error": "no program loaded"}
+	}
+	_, err := machine.Step()
+	return machineState(err)
+}
+
+func machineStepBack(this js.Value, args []js.Value) interface{} {
+	if machine == nil {
+		return map[string]interface{}{"error": "no program loaded"}
+	}
+	return machineState(machine.StepBack())
+}
+
+func machineSeek(this js.Value, args []js.Value) interface{} {
+	if machine == nil {
+		return map[string]interface{}{"error": "no program loaded"}
+	}
+	if len(args) < 1 || args[0].Type() != js.TypeNumber {
+		return map[string]interface{}{"error": "step number required"}
+	}
+	return machineState(machine.Seek(args[0].Int()))
+}
+
+func registerState(state emulator.RegisterState) map[string]interface{} {
+	regs := map[string]interface{}{
+		"rflags": fmt.Sprintf("%x", state.RFlags),
+		"pc":     state.PC,
+	}
+	for i, v := range state.Registers {
+		regs[emulator.RegisterName(emulator.Register(i), 8, true)] = fmt.Sprintf("%d", v)
+	}
+	return regs
+}
+
+func traceSteps(trace []emulator.TraceEntry) []interface{} {
+	steps := make([]interface{}, 0, len(trace))
+	for _, e := range trace {
+		accesses := make([]interface{}, 0, len(e.Memory))
+		for _, a := range e.Memory {
+			accesses = append(accesses, map[string]interface{}{
+				"addr":  fmt.Sprintf("%x", a.Addr),
+				"size":  a.Size,
+				"value": fmt.Sprintf("%x", a.Value),
+				"write": a.Write,
+			})
+		}
+		steps = append(steps, map[string]interface{}{
+			"step":   e.Step,
+			"pc":     e.PC,
+			"bytes":  fmt.Sprintf("%x", e.Bytes),
+			"intel":  e.Intel,
+			"before": registerState(e.Before),
+			"after":  registerState(e.After),
+			"memory": accesses,
+		})
 	}
+	return steps
 }
 
 func genMachineLanguage(this js.Value, args []js.Value) interface{} {
@@ -64,18 +211,141 @@ func genMachineLanguage(this js.Value, args []js.Value) interface{} {
 
 	level := args[0].Int()
 
-	if level < 1 || level > 4 {
+	spec, ok := levels.Get(level)
+	if !ok {
 		return map[string]interface{}{"error": "invalid level"}
 	}
 
-	spaceHex, noSpaceHex, err := genhex.GenerateHex(level)
+	seed := genhex.RandomSeed()
+	if len(args) > 1 && args[1].Type() == js.TypeNumber {
+		f := args[1].Float()
+		if f < 0 || f > genhex.MaxSeed || f != float64(int64(f)) {
+			return map[string]interface{}{"error": "seed must be an integer between 0 and 2147483647"}
+		}
+		seed = int64(f)
+	}
+
+	rules := genhex.DefaultRules()
+	if len(args) > 2 && args[2].Type() == js.TypeObject {
+		if v := args[2].Get("minDifficulty"); v.Type() == js.TypeNumber {
+			rules.MinDifficulty = v.Int()
+		}
+		if v := args[2].Get("maxDifficulty"); v.Type() == js.TypeNumber {
+			rules.MaxDifficulty = v.Int()
+		}
+	}
+
+	spaceHex, noSpaceHex, err := genhex.NewGenerator(levels.Builtin(), rules).Generate(spec, seed)
 	if err != nil {
 		return map[string]interface{}{"error": fmt.Sprintf("error generating hex: %v", err)}
 	}
+	report, err := checker.Check(noSpaceHex, levels.Builtin())
+	if err != nil {
+		return map[string]interface{}{"error": fmt.Sprintf("error checking hex: %v", err)}
+	}
 
 	//return []interface{}{spaceHex, noSpaceHex}
 
 	return map[string]interface{}{
-		"value": []interface{}{spaceHex, noSpaceHex},
+		"value":      []interface{}{spaceHex, noSpaceHex},
+		"seed":       seed,
+		"difficulty": difficultyObject(report.Difficulty()),
+	}
+}
+
+func difficultyObject(d checker.Difficulty) map[string]interface{} {
+	return map[string]interface{}{
+		"score":              d.Score,
+		"ops":                d.Ops,
+		"carries":            d.Carries,
+		"signChanges":        d.SignChanges,
+		"liveRegisters":      d.LiveRegisters,
+		"depth":              d.Depth,
+		"negativeImmediates": d.NegativeImmediates,
+	}
+}
+
+func levelList(this js.Value, args []js.Value) interface{} {
+	var list []interface{}
+	for _, l := range levels.Builtin() {
+		var maxOps interface{}
+		if l.Ops.Max != 0 {
+			maxOps = l.Ops.Max
+		}
+		list = append(list, map[string]interface{}{
+			"level":        l.Level,
+			"title":        l.Title,
+			"description":  l.Description,
+			"valueBits":    l.ValueBits,
+			"registers":    stringList(l.Registers),
+			"instructions": stringList(l.Instructions),
+			"minOps":       l.Ops.Min,
+			"maxOps":       maxOps,
+		})
+	}
+	return list
+}
+
+// stringList converts ss for js.ValueOf, which does not accept []string.
+func stringList(ss []string) []interface{} {
+	list := make([]interface{}, len(ss))
+	for i, s := range ss {
+		list[i] = s
+	}
+	return list
+}
+
+func disassemble(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return map[string]interface{}{"error": "hex string required"}
+	}
+
+	code, err := emulator.ParseHexString(args[0].String())
+	if err != nil {
+		return map[string]interface{}{"error": fmt.Sprintf("error parsing hex input: %v", err)}
+	}
+
+	lines, err := emulator.Disassemble(code)
+	if err != nil {
+		return map[string]interface{}{"error": fmt.Sprintf("disassemble error: %v", err)}
+	}
+
+	value := make([]interface{}, 0, len(lines))
+	for _, line := range lines {
+		value = append(value, map[string]interface{}{
+			"offset": line.Offset,
+			"bytes":  fmt.Sprintf("%x", line.Bytes),
+			"intel":  line.Intel,
+			"att":    line.ATT,
+			"fields": anatomyFields(line),
+		})
+	}
+
+	return map[string]interface{}{
+		"value": value,
+	}
+}
+
+func anatomyFields(line emulator.DisasmLine) []interface{} {
+	var fields []interface{}
+	for _, f := range line.Anatomy() {
+		bits := make([]interface{}, 0, len(f.Bits))
+		for _, b := range f.Bits {
+			bits = append(bits, map[string]interface{}{
+				"name":  b.Name,
+				"width": b.Width,
+				"value": b.Value,
+				"note":  b.Note,
+			})
+		}
+		fields = append(fields, map[string]interface{}{
+			"kind":   f.Kind,
+			"offset": f.Offset,
+			"bytes":  fmt.Sprintf("%x", f.Bytes),
+			"value":  fmt.Sprintf("%d", f.Value),
+			"bits":   bits,
+			"note":   f.Note,
+		})
 	}
+	return fields
 }